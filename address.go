@@ -0,0 +1,277 @@
+package emime
+
+import (
+	"net/textproto"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Address is a parsed mailbox of an address list header.
+type Address struct {
+	Name    string // Display name, decoded to UTF-8.
+	Address string // Address in the form `local@domain`.
+	Group   string // Name of the RFC 5322 group the mailbox belongs to.
+}
+
+// String formats the address as `name <local@domain>`, the name is quoted
+// if needed. Non-ASCII names are encoded as encoded-words by Encode.
+func (a *Address) String() string {
+	if a.Name == "" {
+		return "<" + a.Address + ">"
+	}
+	name := a.Name
+	if strings.IndexFunc(name, func(r rune) bool {
+		return r < 0x80 && r != ' ' && !isAtomChar(byte(r))
+	}) >= 0 {
+		name = quoteString(name)
+	}
+	return name + " <" + a.Address + ">"
+}
+
+// From returns the addresses of the From header.
+func (p *Part) From() ([]*Address, error) { return p.AddressList(hFrom) }
+
+// Sender returns the addresses of the Sender header.
+func (p *Part) Sender() ([]*Address, error) { return p.AddressList(hSender) }
+
+// ReplyTo returns the addresses of the Reply-To header.
+func (p *Part) ReplyTo() ([]*Address, error) { return p.AddressList(hReplyTo) }
+
+// To returns the addresses of the To header.
+func (p *Part) To() ([]*Address, error) { return p.AddressList(hTo) }
+
+// Cc returns the addresses of the Cc header.
+func (p *Part) Cc() ([]*Address, error) { return p.AddressList(hCc) }
+
+// Bcc returns the addresses of the Bcc header.
+func (p *Part) Bcc() ([]*Address, error) { return p.AddressList(hBcc) }
+
+// AddressList parses all the key headers of p as address lists.
+// Mailboxes which could be parsed are returned along with the error
+// of the first one which could not.
+func (p *Part) AddressList(key string) ([]*Address, error) {
+	var addrs []*Address
+	var firstErr error
+	for _, value := range p.Header[textproto.CanonicalMIMEHeaderKey(key)] {
+		list, err := ParseAddressList(value)
+		addrs = append(addrs, list...)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return addrs, firstErr
+}
+
+// ParseAddressList parses an RFC 5322 address list. Besides groups and the
+// obsolete syntax, it tolerates unquoted commas and dots in display names,
+// missing angle brackets, encoded-words inside quoted-strings, and ';' as
+// the list separator. Encoded-words are decoded with any charset of
+// internal/coding. Mailboxes which could be parsed are returned along
+// with the error of the first one which could not.
+func ParseAddressList(s string) ([]*Address, error) {
+	var addrs []*Address
+	var firstErr error
+	var group, pending string
+	inGroup := false
+	for _, seg := range splitAddressList(unfold(s)) {
+		text := seg.text
+		if pending != "" {
+			// only a display name may be continued by the next segment
+			if strings.Contains(text, "<") {
+				text = pending + "," + text
+			} else if firstErr == nil {
+				firstErr = errors.Errorf("invalid address %q", strings.TrimSpace(pending))
+			}
+			pending = ""
+		}
+		if seg.delim == ':' {
+			group = decodePhrase(text)
+			inGroup = true
+			continue
+		}
+		if strings.TrimSpace(text) != "" {
+			addr, err := parseMailbox(text)
+			switch {
+			case err == nil:
+				if inGroup {
+					addr.Group = group
+				}
+				addrs = append(addrs, addr)
+			case seg.delim == ',' && !strings.ContainsAny(text, "<@"):
+				// unquoted comma in a display name, e.g. `Doe, John <j@x>`
+				pending = text
+			case firstErr == nil:
+				firstErr = err
+			}
+		}
+		if seg.delim == ';' {
+			group, inGroup = "", false
+		}
+	}
+	if pending != "" && firstErr == nil {
+		firstErr = errors.Errorf("invalid address %q", strings.TrimSpace(pending))
+	}
+	return addrs, firstErr
+}
+
+// parseMailbox parses `name <addr>`, `addr (name)` or `name addr`.
+func parseMailbox(s string) (*Address, error) {
+	var comment strings.Builder
+	// words of the phrase, quoted display names can not be the address
+	var words []string
+	var quoted []bool
+	word := &strings.Builder{}
+	flush := func() {
+		if word.Len() > 0 {
+			words, quoted = append(words, word.String()), append(quoted, false)
+			word.Reset()
+		}
+	}
+	addr, angled := "", false
+	for i := 0; i < len(s); {
+		switch c := s[i]; c {
+		case '"':
+			j := skipQuoted(s, i)
+			if j < len(s) && s[j] == '@' && !angled {
+				// quoted local part
+				word.WriteString(s[i:j])
+			} else {
+				flush()
+				words = append(words, unquote(strings.TrimSuffix(s[i+1:j], `"`)))
+				quoted = append(quoted, true)
+			}
+			i = j
+		case '(':
+			flush()
+			j := skipComment(s, i)
+			if comment.Len() > 0 {
+				comment.WriteByte(' ')
+			}
+			comment.WriteString(unquote(strings.TrimSuffix(s[i+1:j], ")")))
+			i = j
+		case '<':
+			flush()
+			j := strings.IndexByte(s[i:], '>')
+			if j < 0 {
+				// missing '>'
+				j = len(s) - i
+			}
+			if !angled {
+				addr, angled = s[i+1:i+j], true
+			}
+			i += j + 1
+		case ' ', '\t', '\r', '\n':
+			flush()
+			i++
+		default:
+			word.WriteByte(c)
+			i++
+		}
+	}
+	flush()
+
+	if !angled {
+		for i := len(words) - 1; i >= 0; i-- {
+			if !quoted[i] && strings.Contains(words[i], "@") {
+				addr = words[i]
+				words = append(words[:i], words[i+1:]...)
+				break
+			}
+		}
+	}
+	name := strings.Join(words, " ")
+	// obsolete route, `<@host1,@host2:local@domain>`
+	if strings.HasPrefix(strings.TrimSpace(addr), "@") {
+		if i := strings.LastIndexByte(addr, ':'); i >= 0 {
+			addr = addr[i+1:]
+		}
+	}
+	addr = strings.Trim(removeSpace(addr), ".,;")
+	if at := strings.LastIndexByte(addr, '@'); at <= 0 || at == len(addr)-1 {
+		if !angled || addr != "" {
+			return nil, errors.Errorf("invalid address %q", strings.TrimSpace(s))
+		}
+	}
+	name = decodePhrase(strings.Trim(name, " \t,;"))
+	if name == "" {
+		name = decodePhrase(comment.String())
+	}
+	return &Address{Name: name, Address: addr}, nil
+}
+
+// removeSpace removes the white space of an address outside of quoted
+// local parts.
+func removeSpace(addr string) string {
+	var b strings.Builder
+	for i := 0; i < len(addr); {
+		switch c := addr[i]; c {
+		case '"':
+			j := skipQuoted(addr, i)
+			b.WriteString(addr[i:j])
+			i = j
+		case ' ', '\t', '\r', '\n':
+			i++
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// decodePhrase unquotes a display name, decodes its encoded-words and
+// collapses its white spaces.
+func decodePhrase(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = unquote(s[1 : len(s)-1])
+	}
+	if hasNonASCII(s) {
+		return s
+	}
+	return decodeHeader(s)
+}
+
+// skipQuoted returns the index after the quoted-string starting at s[i].
+func skipQuoted(s string, i int) int {
+	for i++; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// skipComment returns the index after the, possibly nested, comment
+// starting at s[i].
+func skipComment(s string, i int) int {
+	depth := 0
+	for ; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(s)
+}
+
+// unfold removes the CRLFs of a folded header value.
+func unfold(s string) string {
+	return strings.NewReplacer("\r\n", "", "\n", "").Replace(s)
+}
+
+// isAtomChar reports whether c is an RFC 5322 atext character.
+func isAtomChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		strings.IndexByte("!#$%&'*+-/=?^_`{|}~", c) >= 0
+}
@@ -0,0 +1,85 @@
+package emime
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAddressList(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []Address
+	}{
+		{`John Doe <john@example.com>`, []Address{{"John Doe", "john@example.com", ""}}},
+		{`"Doe, John" <john@example.com>, jane@example.com`,
+			[]Address{{"Doe, John", "john@example.com", ""}, {"", "jane@example.com", ""}}},
+		// unquoted comma and dot in the display name
+		{`Doe, John Q. <john@example.com>`, []Address{{"Doe, John Q.", "john@example.com", ""}}},
+		{`john@example.com (John Doe)`, []Address{{"John Doe", "john@example.com", ""}}},
+		{`=?gb2312?b?1tDOxA==?= <zh@example.com>`, []Address{{"中文", "zh@example.com", ""}}},
+		{`"=?iso-8859-1?q?Andr=E9?=" <andre@example.com>`, []Address{{"André", "andre@example.com", ""}}},
+		{`=?koi8-r?b?98HT0Q==?= <vasya@example.com>`, []Address{{"Вася", "vasya@example.com", ""}}},
+		{`Team: a@example.com, B <b@example.com>;, c@example.com`,
+			[]Address{{"", "a@example.com", "Team"}, {"B", "b@example.com", "Team"}, {"", "c@example.com", ""}}},
+		{`undisclosed-recipients:;`, nil},
+		{`<@relay1.example,@relay2.example:obs@example.com>`, []Address{{"", "obs@example.com", ""}}},
+		{`a@example.com; b@example.com`, []Address{{"", "a@example.com", ""}, {"", "b@example.com", ""}}},
+		{"Broken <broken@example.com", []Address{{"Broken", "broken@example.com", ""}}},
+		{"Folded\r\n Name <folded@example.com>", []Address{{"Folded Name", "folded@example.com", ""}}},
+		{`Name noangle@example.com`, []Address{{"Name", "noangle@example.com", ""}}},
+		{`<>`, []Address{{"", "", ""}}},
+		{`"john doe"@example.com`, []Address{{"", `"john doe"@example.com`, ""}}},
+		{`"Doe, John" "john doe"@example.com`, []Address{{"Doe, John", `"john doe"@example.com`, ""}}},
+		{`John <"john doe"@example.com>`, []Address{{"John", `"john doe"@example.com`, ""}}},
+	}
+	for _, tt := range tests {
+		addrs, err := ParseAddressList(tt.input)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.input, err)
+		}
+		if len(addrs) != len(tt.want) {
+			t.Fatalf("%q: got: %d addresses, want: %d", tt.input, len(addrs), len(tt.want))
+		}
+		for i, addr := range addrs {
+			if *addr != tt.want[i] {
+				t.Fatalf("%q: got: %+v, want: %+v", tt.input, *addr, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestParseAddressListError(t *testing.T) {
+	addrs, err := ParseAddressList(`good@example.com, not an address, other@example.com`)
+	if err == nil {
+		t.Fatal("want error")
+	}
+	if len(addrs) != 2 {
+		t.Fatalf("got: %d addresses, want: 2", len(addrs))
+	}
+}
+
+func TestPartAddresses(t *testing.T) {
+	input := "From: =?utf-8?q?Jos=C3=A9?= <jose@example.com>\r\n" +
+		"To: a@example.com\r\n" +
+		"To: b@example.com\r\n" +
+		"\r\n" +
+		"body"
+	root, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, err := root.From()
+	if err != nil || len(from) != 1 || from[0].Name != "José" {
+		t.Fatalf("got: %v %v", from, err)
+	}
+	if got := from[0].String(); got != "José <jose@example.com>" {
+		t.Fatalf("got: %s, want: %s", got, "José <jose@example.com>")
+	}
+	to, err := root.To()
+	if err != nil || len(to) != 2 {
+		t.Fatalf("got: %v %v", to, err)
+	}
+	if cc, err := root.Cc(); err != nil || cc != nil {
+		t.Fatalf("got: %v %v", cc, err)
+	}
+}
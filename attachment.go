@@ -1,5 +1,10 @@
 package emime
 
+import (
+	"bytes"
+	"net/textproto"
+)
+
 type Attachment struct {
 	AttachmentID string // AttachmentID ID for the attachment.
 	ContentType  string // ContentType header without parameters.
@@ -29,13 +34,9 @@ func part2Attachment(part *Part) *Attachment {
 		Disposition:  part.Disposition,
 		FileName:     part.FileName,
 	}
-	if part.ContentType == ctRFC822 {
-		// TODO: if `message/rfc822` attachment is not base64 encoded,
-		// this will return empty data. Should encode the part sub tree instead.
-		if len(part.Parts) > 0 {
-			attachment.Data = part.Parts[0].Content
-			attachment.Size = len(part.Parts[0].Content)
-		}
+	if part.isMessage() {
+		attachment.Data = messageData(part)
+		attachment.Size = len(attachment.Data)
 	} else {
 		attachment.Data = part.Content
 		attachment.Size = len(part.Content)
@@ -43,6 +44,56 @@ func part2Attachment(part *Part) *Attachment {
 	return attachment
 }
 
+// messageData returns the encapsulated message of a `message/rfc822` part,
+// its original bytes if it is unmodified. The tree is not changed, except
+// for a defect recorded if the message fails to encode, its original bytes
+// are returned then if kept.
+func messageData(part *Part) []byte {
+	if len(part.Parts) == 0 {
+		// sub tree dropped as malformed, or built with the content
+		if len(part.Content) == 0 && part.raw != nil {
+			return part.raw.data[part.raw.headerLen:]
+		}
+		return part.Content
+	}
+	child := part.Parts[0]
+	// base64 attachment, the message is kept as the child content
+	if !part.isEncodedMessage() && lowerTrim(part.Header.Get(hContentEncoding)) == cteBase64 {
+		return child.Content
+	}
+	if child.raw != nil && child.unmodified() {
+		return child.raw.data
+	}
+	// the data is not for transport, 8-bit content is kept
+	buf := &bytes.Buffer{}
+	opts := &EncodeOptions{Allow8BitMIME: true, AllowBinaryMIME: true}
+	if err := child.clone(nil).EncodeWithOptions(buf, opts); err != nil {
+		part.addDefect(DefectMalformedMessage, part.offset, "encapsulated message not encoded: %v", err)
+		if child.raw != nil {
+			return child.raw.data
+		}
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// clone returns a copy of the tree of p with parent as its parent, the
+// headers are copied so that the copy can be encoded on its own.
+func (p *Part) clone(parent *Part) *Part {
+	c := *p
+	c.Parent = parent
+	c.Header = make(textproto.MIMEHeader, len(p.Header))
+	for k, v := range p.Header {
+		c.Header[k] = append([]string(nil), v...)
+	}
+	c.HeaderKeys = append([]string(nil), p.HeaderKeys...)
+	c.Parts = make([]*Part, len(p.Parts))
+	for i, child := range p.Parts {
+		c.Parts[i] = child.clone(&c)
+	}
+	return &c
+}
+
 func appendAttachments(root *Part, attachments *[]*Attachment) {
 	if root == nil {
 		return
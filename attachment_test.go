@@ -0,0 +1,93 @@
+package emime
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGetAttachmentsMessage(t *testing.T) {
+	inner := "From: a@example.com\r\n" +
+		"Subject: forwarded\r\n" +
+		"Content-Type: multipart/alternative; boundary=inner\r\n" +
+		"\r\n" +
+		"--inner\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"text\r\n" +
+		"--inner--\r\n"
+	input := "Content-Type: multipart/mixed; boundary=outer\r\n" +
+		"\r\n" +
+		"--outer\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"see attached\r\n" +
+		"--outer\r\n" +
+		"Content-Type: message/rfc822\r\n" +
+		"Content-Disposition: attachment; filename=fwd.eml\r\n" +
+		"\r\n" +
+		inner +
+		"\r\n--outer--\r\n"
+
+	root, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachments := GetAttachments(root)
+	if len(attachments) != 1 {
+		t.Fatalf("got: %d attachments, want: 1", len(attachments))
+	}
+	if got := string(attachments[0].Data); got != inner {
+		t.Fatalf("got: %q, want: %q", got, inner)
+	}
+
+	root, err = Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachments = GetAttachments(root)
+	data := attachments[0].Data
+	msg, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.Get("Subject") != "forwarded" || len(msg.Parts) != 1 || string(msg.Parts[0].Content) != "text" {
+		t.Fatalf("got: %q", data)
+	}
+}
+
+func TestGetAttachmentsUnchanged(t *testing.T) {
+	inner := "Subject: 8bit\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"Grüße\r\n"
+	input := "Content-Type: multipart/mixed; boundary=outer\r\n" +
+		"\r\n" +
+		"--outer\r\n" +
+		"Content-Type: message/rfc822\r\n" +
+		"Content-Disposition: attachment\r\n" +
+		"\r\n" +
+		inner +
+		"--outer--\r\n"
+	for _, opts := range []*ParseOptions{{KeepRaw: true}, nil} {
+		root, err := ParseWithOptions(strings.NewReader(input), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := root.Parts[0].Parts[0]
+		attachments := GetAttachments(root)
+		if opts != nil && string(attachments[0].Data) != strings.TrimSuffix(inner, "\r\n") {
+			t.Fatalf("got: %q, want: %q", attachments[0].Data, inner)
+		}
+		if cte := msg.Header.Get(hContentEncoding); cte != "" || len(msg.HeaderKeys) != 2 {
+			t.Fatalf("got: %q, %q, want: the header unchanged", cte, msg.HeaderKeys)
+		}
+		data, err := Parse(bytes.NewReader(attachments[0].Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data.Content) != "Grüße" {
+			t.Fatalf("got: %q, want: Grüße", data.Content)
+		}
+	}
+}
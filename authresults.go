@@ -0,0 +1,199 @@
+package emime
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const hAuthenticationResults = "Authentication-Results"
+
+// AuthResults is a parsed Authentication-Results header, RFC 8601.
+type AuthResults struct {
+	AuthServID string // Host which did the checks, "" if it was left out.
+	Version    int    // 1 unless given.
+	Results    []*AuthResult
+	Raw        string // Unfolded header value.
+}
+
+// AuthResult is the result of one authentication method.
+type AuthResult struct {
+	Method string // Lower case, e.g. spf, dkim, dmarc or arc.
+	Result string // Lower case, e.g. pass, fail, softfail or none.
+	Reason string
+	// Properties by lower case "ptype.property", e.g. "header.d",
+	// "header.from" or "smtp.mailfrom".
+	Properties map[string]string
+}
+
+// Result returns the first result of method, or nil.
+func (a *AuthResults) Result(method string) *AuthResult {
+	for _, r := range a.Results {
+		if strings.EqualFold(r.Method, method) {
+			return r
+		}
+	}
+	return nil
+}
+
+// AuthenticationResults returns the Authentication-Results headers of p,
+// the newest first. Headers which can not be parsed are skipped.
+func (p *Part) AuthenticationResults() []*AuthResults {
+	var results []*AuthResults
+	for _, value := range p.Header[hAuthenticationResults] {
+		if res, err := ParseAuthenticationResults(value); err == nil {
+			results = append(results, res)
+		}
+	}
+	return results
+}
+
+// ParseAuthenticationResults parses the value of an Authentication-Results
+// header. Comments and folding are ignored, malformed results are skipped
+// up to the next semicolon. Values which are not valid tokens, such as
+// SRS addresses in smtp.mailfrom, are kept as they are.
+func ParseAuthenticationResults(value string) (*AuthResults, error) {
+	raw := strings.Join(strings.Fields(unfold(value)), " ")
+	a := &AuthResults{Version: 1, Raw: raw}
+	s := &authResScanner{s: raw}
+
+	s.skipCFWS()
+	id := s.value(";=")
+	s.skipCFWS()
+	if s.peek() == '=' {
+		// the authserv-id is missing, the header starts with a result
+		s.i = 0
+	} else {
+		if id == "" {
+			return nil, errors.New("missing authserv-id")
+		}
+		a.AuthServID = id
+		if start := s.i; s.peek() != ';' && !s.eof() {
+			if v, err := strconv.Atoi(s.value(";")); err == nil && v > 0 {
+				a.Version = v
+			} else {
+				s.i = start
+			}
+		}
+		s.skipCFWS()
+		if !s.eof() && s.peek() != ';' {
+			return nil, errors.Errorf("unexpected %q after authserv-id", s.s[s.i:])
+		}
+	}
+
+	for {
+		s.skipCFWS()
+		if s.eof() {
+			break
+		}
+		if s.peek() == ';' {
+			s.i++
+			continue
+		}
+		if r := s.result(); r != nil {
+			a.Results = append(a.Results, r)
+		}
+		// skip what could not be parsed
+		for !s.eof() && s.peek() != ';' {
+			switch s.peek() {
+			case '(':
+				s.skipCFWS()
+			case '"':
+				s.i = skipQuoted(s.s, s.i)
+			default:
+				s.i++
+			}
+		}
+	}
+	return a, nil
+}
+
+// result parses `method[/version]=result [reason=value] [ptype.property=value...]`,
+// or returns nil for "none" and malformed results.
+func (s *authResScanner) result() *AuthResult {
+	method := strings.ToLower(s.value(";=/"))
+	s.skipCFWS()
+	if s.peek() == '/' {
+		s.i++
+		s.skipCFWS()
+		s.value(";=")
+		s.skipCFWS()
+	}
+	if method == "" || s.peek() != '=' {
+		return nil
+	}
+	s.i++
+	s.skipCFWS()
+	r := &AuthResult{Method: method, Result: strings.ToLower(s.value(";=")), Properties: make(map[string]string)}
+	if r.Result == "" {
+		return nil
+	}
+	for {
+		s.skipCFWS()
+		if s.eof() || s.peek() == ';' {
+			return r
+		}
+		key := strings.ToLower(s.value(";="))
+		s.skipCFWS()
+		if key == "" || s.peek() != '=' {
+			return r
+		}
+		s.i++
+		s.skipCFWS()
+		v := s.value(";")
+		if key == "reason" {
+			r.Reason = v
+		} else {
+			r.Properties[key] = v
+		}
+	}
+}
+
+// authResScanner scans the value of an Authentication-Results header.
+type authResScanner struct {
+	s string
+	i int
+}
+
+func (s *authResScanner) eof() bool { return s.i >= len(s.s) }
+
+func (s *authResScanner) peek() byte {
+	if s.eof() {
+		return 0
+	}
+	return s.s[s.i]
+}
+
+// skipCFWS skips whitespace and comments.
+func (s *authResScanner) skipCFWS() {
+	for !s.eof() {
+		switch s.peek() {
+		case ' ', '\t':
+			s.i++
+		case '(':
+			s.i = skipComment(s.s, s.i)
+		default:
+			return
+		}
+	}
+}
+
+// value consumes a quoted-string or a word ending before whitespace, a
+// comment or one of stop.
+func (s *authResScanner) value(stop string) string {
+	if s.peek() == '"' {
+		v, rest := consumeValue(s.s[s.i:])
+		if len(rest) < len(s.s)-s.i {
+			s.i = len(s.s) - len(rest)
+			return v
+		}
+		s.i = skipQuoted(s.s, s.i)
+		return ""
+	}
+	start := s.i
+	for !s.eof() && strings.IndexByte(" \t(\""+stop, s.peek()) < 0 {
+		s.i++
+	}
+	return s.s[start:s.i]
+}
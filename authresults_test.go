@@ -0,0 +1,79 @@
+package emime
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseAuthenticationResults(t *testing.T) {
+	type props = map[string]string
+	tests := []struct {
+		input string
+		want  AuthResults
+	}{
+		// Gmail
+		{"mx.google.com;\r\n" +
+			"       dkim=pass header.i=@example.com header.s=sel header.b=AbC+/12x;\r\n" +
+			"       spf=pass (google.com: domain of SRS0=x1=ab=example.org=bob@example.com designates 192.0.2.1 as permitted sender)" +
+			" smtp.mailfrom=\"SRS0=x1=ab=example.org=bob@example.com\";\r\n" +
+			"       dmarc=pass (p=REJECT sp=REJECT dis=NONE) header.from=example.com",
+			AuthResults{AuthServID: "mx.google.com", Version: 1, Results: []*AuthResult{
+				{Method: "dkim", Result: "pass", Properties: props{"header.i": "@example.com", "header.s": "sel", "header.b": "AbC+/12x"}},
+				{Method: "spf", Result: "pass", Properties: props{"smtp.mailfrom": "SRS0=x1=ab=example.org=bob@example.com"}},
+				{Method: "dmarc", Result: "pass", Properties: props{"header.from": "example.com"}},
+			}}},
+		// Microsoft
+		{"spf=softfail (sender IP is 192.0.2.9)\r\n smtp.mailfrom=example.org; dkim=none (message not signed)\r\n" +
+			" header.d=none;dmarc=fail action=none header.from=example.org;compauth=fail reason=601",
+			AuthResults{Version: 1, Results: []*AuthResult{
+				{Method: "spf", Result: "softfail", Properties: props{"smtp.mailfrom": "example.org"}},
+				{Method: "dkim", Result: "none", Properties: props{"header.d": "none"}},
+				{Method: "dmarc", Result: "fail", Properties: props{"action": "none", "header.from": "example.org"}},
+				{Method: "compauth", Result: "fail", Reason: "601", Properties: props{}},
+			}}},
+		// RFC 8601 appendix B
+		{"example.com (comment) 1 ; auth=pass (cram-md5) smtp.auth=sender@example.net;\r\n" +
+			"  spf/1 = pass smtp.mailfrom=example.net; sender-id=fail (nope\r\n (nested)) header.from=example.net;" +
+			" dkim=fail reason=\"bad signature\" header.i=@mail-router.example.net",
+			AuthResults{AuthServID: "example.com", Version: 1, Results: []*AuthResult{
+				{Method: "auth", Result: "pass", Properties: props{"smtp.auth": "sender@example.net"}},
+				{Method: "spf", Result: "pass", Properties: props{"smtp.mailfrom": "example.net"}},
+				{Method: "sender-id", Result: "fail", Properties: props{"header.from": "example.net"}},
+				{Method: "dkim", Result: "fail", Reason: "bad signature", Properties: props{"header.i": "@mail-router.example.net"}},
+			}}},
+		{"mx.example.org; none", AuthResults{AuthServID: "mx.example.org", Version: 1}},
+		{"mx.example.org 2; arc=PASS (i=2) smtp.remote-ip=192.0.2.1; garbage; ;dkim=",
+			AuthResults{AuthServID: "mx.example.org", Version: 2, Results: []*AuthResult{
+				{Method: "arc", Result: "pass", Properties: props{"smtp.remote-ip": "192.0.2.1"}},
+			}}},
+	}
+	for _, tt := range tests {
+		got, err := ParseAuthenticationResults(tt.input)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.input, err)
+		}
+		got.Raw = ""
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Fatalf("got: %+v, want: %+v", got, tt.want)
+		}
+	}
+
+	for _, input := range []string{"", " (comment) ", "mx.example.org spf=pass"} {
+		if _, err := ParseAuthenticationResults(input); err == nil {
+			t.Fatalf("%q: want error", input)
+		}
+	}
+
+	p, err := Parse(strings.NewReader("Authentication-Results: mx.example.net; dkim=pass header.d=example.com\r\n" +
+		"Authentication-Results: mx.example.net;\r\n\tspf=fail smtp.mailfrom=example.com\r\n" +
+		"Subject: test\r\n\r\nbody\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := p.AuthenticationResults()
+	if len(results) != 2 || results[0].Result("DKIM").Properties["header.d"] != "example.com" ||
+		results[1].Result("spf").Result != "fail" || results[1].Result("dkim") != nil {
+		t.Fatalf("got: %+v", results)
+	}
+}
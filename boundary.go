@@ -18,6 +18,7 @@ type BoundaryReader struct {
 	br        *bufio.Reader
 	partsRead int
 	finished  bool
+	strict    bool // strict delimiter matching
 
 	nl               []byte // "\r\n" or "\n" (set after seeing first boundary line)
 	nlDashBoundary   []byte // nl + "--boundary"
@@ -40,8 +41,8 @@ func NewBoundaryReader(r *bufio.Reader, boundary string) *BoundaryReader {
 // Read reads from buffer until the next boundary.
 func (b *BoundaryReader) Read(dest []byte) (int, error) {
 	peek, err := b.br.Peek(peakSize)
-	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
-		return 0, errors.WithStack(err)
+	if err == bufio.ErrBufferFull {
+		err = nil
 	}
 	var nRead int
 	idx := findBoundary(peek, b.nlDashBoundary)
@@ -63,6 +64,9 @@ func (b *BoundaryReader) Read(dest []byte) (int, error) {
 				} else {
 					return 0, io.EOF
 				}
+			} else if err != nil {
+				// return buffered data before the error
+				return 0, errors.WithStack(err)
 			}
 		}
 	}
@@ -84,7 +88,7 @@ func (b *BoundaryReader) NextPart() (bool, error) {
 	for {
 		line, err := b.br.ReadSlice('\n')
 		if err != nil && err != io.EOF {
-			return false, errors.Errorf("boundary: NextPart: %v", err)
+			return false, errors.Wrap(err, "boundary: NextPart")
 		}
 
 		if b.isDelimiter(line) {
@@ -107,10 +111,20 @@ func (b *BoundaryReader) NextPart() (bool, error) {
 			continue
 		}
 		b.finished = true
-		return false, fmt.Errorf("boundary: unexpected line in NextPart(): %q", line)
+		return false, &unexpectedLineError{line: append([]byte(nil), line...)}
 	}
 }
 
+// unexpectedLineError is returned by NextPart for a line which is neither
+// a delimiter nor a blank line after the first part.
+type unexpectedLineError struct {
+	line []byte
+}
+
+func (e *unexpectedLineError) Error() string {
+	return fmt.Sprintf("boundary: unexpected line in NextPart(): %q", e.line)
+}
+
 // matches `^--boundary[ \t]*[\r\n]$`
 func (b *BoundaryReader) isDelimiter(line []byte) bool {
 	if !bytes.HasPrefix(line, b.dashBoundary) {
@@ -123,6 +137,9 @@ func (b *BoundaryReader) isDelimiter(line []byte) bool {
 		b.nl = b.nl[1:]
 		b.nlDashBoundary = b.nlDashBoundary[1:]
 	}
+	if b.strict {
+		return bytes.Equal(rest, b.nl)
+	}
 	// more tolerant ending
 	if len(rest) > 0 {
 		if rest[0] == '\r' || rest[0] == '\n' {
@@ -133,15 +150,16 @@ func (b *BoundaryReader) isDelimiter(line []byte) bool {
 }
 
 // matches `^--boundary--*`,
-// more strict should matche `^--boundary--[ \t]*(\r\n)?$`
+// in strict mode matches `^--boundary--[ \t]*(\r\n)?$`
 func (b *BoundaryReader) isTerminator(line []byte) bool {
 	if !bytes.HasPrefix(line, b.dashBoundaryDash) {
 		return false
 	}
-	// matches `^--boundary--[ \t]*(\r\n)?$`
-	// rest := line[len(b.dashBoundaryDash):]
-	// rest = bytes.TrimLeft(rest, " \t")
-	// return len(rest) == 0 || bytes.Equal(rest, b.nl)
+	if b.strict {
+		rest := line[len(b.dashBoundaryDash):]
+		rest = bytes.TrimLeft(rest, " \t")
+		return len(rest) == 0 || bytes.Equal(rest, b.nl)
+	}
 
 	return true // more tolerant ending
 }
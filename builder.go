@@ -0,0 +1,268 @@
+package emime
+
+import (
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Builder composes a new email into a Part tree ready for Encode:
+//
+//	root, err := emime.NewBuilder().
+//		From(&emime.Address{Name: "Alice", Address: "alice@example.com"}).
+//		To(&emime.Address{Address: "bob@example.com"}).
+//		Subject("Hello").
+//		Text("Hello Bob").
+//		HTML(`<p>Hello Bob</p><img src="cid:logo.png">`).
+//		Inline("logo.png", "image/png", logo).
+//		Attach("report.pdf", "application/pdf", report).
+//		Build()
+//
+// Text and HTML bodies are sent as multipart/alternative, inline files
+// are related to the HTML body with multipart/related, and attachments
+// go into multipart/mixed. Transfer encodings are picked from the content
+// when the message is encoded, as the EncodeOptions allow.
+type Builder struct {
+	header      textproto.MIMEHeader
+	keys        []string
+	text        *string
+	html        *string
+	inlines     []*builderFile
+	attachments []*builderFile
+}
+
+type builderFile struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+// NewBuilder returns an empty Builder.
+func NewBuilder() *Builder {
+	return &Builder{header: make(textproto.MIMEHeader)}
+}
+
+// From sets the From header.
+func (b *Builder) From(addrs ...*Address) *Builder { return b.addresses(hFrom, addrs) }
+
+// To sets the To header.
+func (b *Builder) To(addrs ...*Address) *Builder { return b.addresses(hTo, addrs) }
+
+// Cc sets the Cc header.
+func (b *Builder) Cc(addrs ...*Address) *Builder { return b.addresses(hCc, addrs) }
+
+// ReplyTo sets the Reply-To header.
+func (b *Builder) ReplyTo(addrs ...*Address) *Builder { return b.addresses(hReplyTo, addrs) }
+
+// Subject sets the Subject header, non-ASCII text is encoded by Encode.
+func (b *Builder) Subject(subject string) *Builder { return b.Header("Subject", subject) }
+
+// Date sets the Date header, the time of Build is used by default.
+func (b *Builder) Date(t time.Time) *Builder { return b.Header("Date", t.Format(time.RFC1123Z)) }
+
+// Header sets the header key to value, replacing any previous value.
+func (b *Builder) Header(key, value string) *Builder {
+	if _, ok := b.header[textproto.CanonicalMIMEHeaderKey(key)]; !ok {
+		b.keys = append(b.keys, key)
+	}
+	b.header.Set(key, value)
+	return b
+}
+
+func (b *Builder) addresses(key string, addrs []*Address) *Builder {
+	list := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		list = append(list, addr.String())
+	}
+	return b.Header(key, strings.Join(list, ", "))
+}
+
+// Text sets the plain text body.
+func (b *Builder) Text(text string) *Builder {
+	b.text = &text
+	return b
+}
+
+// HTML sets the HTML body. Inline files are referenced as `cid:name`.
+func (b *Builder) HTML(html string) *Builder {
+	b.html = &html
+	return b
+}
+
+// Inline adds a file, e.g. an image, shown inside the HTML body. The
+// `cid:name` references of the HTML body are rewritten to its Content-ID.
+func (b *Builder) Inline(name, contentType string, data []byte) *Builder {
+	b.inlines = append(b.inlines, &builderFile{name: name, contentType: contentType, data: data})
+	return b
+}
+
+// Attach adds a file attachment.
+func (b *Builder) Attach(name, contentType string, data []byte) *Builder {
+	b.attachments = append(b.attachments, &builderFile{name: name, contentType: contentType, data: data})
+	return b
+}
+
+// Build returns the root of the composed Part tree. The From header is
+// required, Date, Message-ID and MIME-Version are added if missing.
+func (b *Builder) Build() (*Part, error) {
+	domain, err := b.domain()
+	if err != nil {
+		return nil, err
+	}
+
+	// bodies, from the innermost
+	var body *Part
+	if b.html != nil {
+		body = b.htmlPart(domain)
+	}
+	if b.text != nil || body == nil {
+		text := ""
+		if b.text != nil {
+			text = *b.text
+		}
+		textPart := newTextPart(ctTextPlain, text)
+		if body != nil {
+			body = newMultipart("alternative", textPart, body)
+		} else {
+			body = textPart
+		}
+	}
+	if len(b.attachments) > 0 {
+		parts := []*Part{body}
+		for _, f := range b.attachments {
+			parts = append(parts, newFilePart(f, cdAttachment))
+		}
+		body = newMultipart("mixed", parts...)
+	}
+	return b.message(body, domain), nil
+}
+
+// domain returns the domain of the From address, for message ids.
+func (b *Builder) domain() (string, error) {
+	from, err := ParseAddressList(b.header.Get(hFrom))
+	if err != nil || len(from) == 0 {
+		return "", errors.Errorf("builder: invalid From %q", b.header.Get(hFrom))
+	}
+	if i := strings.LastIndexByte(from[0].Address, '@'); i >= 0 {
+		return from[0].Address[i+1:], nil
+	}
+	return "localhost", nil
+}
+
+// message makes body the root of the message, adding the message headers.
+func (b *Builder) message(body *Part, domain string) *Part {
+	// message headers go before the content headers of the body
+	header := make(textproto.MIMEHeader)
+	keys := make([]string, 0, len(b.keys)+len(body.HeaderKeys)+3)
+	for _, k := range b.keys {
+		ck := textproto.CanonicalMIMEHeaderKey(k)
+		header[ck] = append([]string(nil), b.header[ck]...)
+		keys = append(keys, k)
+	}
+	defaults := [][2]string{
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", "<" + genMessageID(domain) + ">"},
+		{"MIME-Version", "1.0"},
+	}
+	for _, kv := range defaults {
+		if header.Get(kv[0]) == "" {
+			header.Set(kv[0], kv[1])
+			keys = append(keys, kv[0])
+		}
+	}
+	for _, k := range body.HeaderKeys {
+		ck := textproto.CanonicalMIMEHeaderKey(k)
+		header[ck] = body.Header[ck]
+		keys = append(keys, k)
+	}
+	body.Header, body.HeaderKeys = header, keys
+	return body
+}
+
+// htmlPart returns the HTML body, related to the inline files.
+func (b *Builder) htmlPart(domain string) *Part {
+	html := *b.html
+	var inlines []*Part
+	for _, f := range b.inlines {
+		p := newFilePart(f, cdInline)
+		id := genMessageID(domain)
+		p.setHeader(hContentID, "<"+id+">")
+		p.ContentID = "<" + id + ">"
+		for _, quote := range []string{`"`, `'`, `(`} {
+			end := quote
+			if quote == `(` {
+				end = `)`
+			}
+			html = strings.Replace(html, quote+"cid:"+f.name+end, quote+"cid:"+id+end, -1)
+		}
+		inlines = append(inlines, p)
+	}
+	p := newTextPart(ctTextHTML, html)
+	if len(inlines) == 0 {
+		return p
+	}
+	return newMultipart("related", append([]*Part{p}, inlines...)...)
+}
+
+// newTextPart returns a UTF-8 text part, line breaks are made CRLF.
+func newTextPart(contentType, text string) *Part {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	text = strings.Replace(text, "\n", "\r\n", -1)
+	p := &Part{Header: make(textproto.MIMEHeader), ContentType: contentType, Charset: "utf-8"}
+	p.Content = []byte(text)
+	p.setHeader(hContentType, formatMediaType(contentType, map[string]string{hpCharset: "utf-8"}))
+	return p
+}
+
+// newFilePart returns an attachment or inline file part.
+func newFilePart(f *builderFile, disposition string) *Part {
+	contentType := strings.ToLower(f.contentType)
+	if contentType == "" {
+		contentType = ctAppOctetStream
+	}
+	p := &Part{
+		Header:      make(textproto.MIMEHeader),
+		ContentType: contentType,
+		Disposition: disposition,
+		FileName:    f.name,
+		Content:     f.data,
+	}
+	// text files are kept in their own charset
+	p.rawCharset = true
+	p.setHeader(hContentType, formatMediaType(contentType, map[string]string{hpName: f.name}))
+	p.setHeader(hContentDisposition, formatMediaType(disposition, map[string]string{hpFileName: f.name}))
+	return p
+}
+
+// newMultipart returns a multipart/subtype part of parts.
+func newMultipart(subtype string, parts ...*Part) *Part {
+	p := &Part{Header: make(textproto.MIMEHeader), ContentType: ctMultipartPrefix + subtype}
+	for _, child := range parts {
+		p.AddChild(child)
+	}
+	// nested boundaries must differ
+	for p.Boundary == "" || hasBoundary(p.Parts, p.Boundary) {
+		p.Boundary = genRandomBoundary()
+	}
+	p.setHeader(hContentType, formatMediaType(p.ContentType, map[string]string{hpBoundary: p.Boundary}))
+	return p
+}
+
+func hasBoundary(parts []*Part, boundary string) bool {
+	for _, p := range parts {
+		if p.Boundary == boundary || hasBoundary(p.Parts, boundary) {
+			return true
+		}
+	}
+	return false
+}
+
+// setHeader sets the header key to value, adding key to HeaderKeys.
+func (p *Part) setHeader(key, value string) {
+	if _, ok := p.Header[textproto.CanonicalMIMEHeaderKey(key)]; !ok {
+		p.HeaderKeys = append(p.HeaderKeys, key)
+	}
+	p.Header.Set(key, value)
+}
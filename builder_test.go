@@ -0,0 +1,88 @@
+package emime
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	logo := []byte("\x89PNG\r\n\x1a\n\x00\x00")
+	root, err := NewBuilder().
+		From(&Address{Name: "Zoë", Address: "zoe@example.com"}).
+		To(&Address{Name: "Bob", Address: "bob@example.com"}, &Address{Address: "carol@example.com"}).
+		Subject("Grüße").
+		Text("Hello\nBob").
+		HTML(`<p>Hello</p><img src="cid:logo.png">`).
+		Inline("logo.png", "image/png", logo).
+		Attach("报告.txt", "text/plain", []byte("plain text")).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := root.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if hasNonASCII(buf.String()) {
+		t.Fatalf("got non-ASCII output: %q", buf.String())
+	}
+
+	parsed, err := Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	var walk func(p *Part)
+	walk = func(p *Part) {
+		types = append(types, p.ContentType)
+		for _, child := range p.Parts {
+			walk(child)
+		}
+	}
+	walk(parsed)
+	want := "multipart/mixed,multipart/alternative,text/plain,multipart/related,text/html,image/png,text/plain"
+	if got := strings.Join(types, ","); got != want {
+		t.Fatalf("got: %s, want: %s", got, want)
+	}
+	if got := decodeHeader(parsed.Header.Get("Subject")); got != "Grüße" {
+		t.Fatalf("got: %q, want: %q", got, "Grüße")
+	}
+	from, err := parsed.From()
+	if err != nil || from[0].Name != "Zoë" {
+		t.Fatalf("got: %v %v", from, err)
+	}
+	if parsed.Header.Get("Message-Id") == "" || parsed.Header.Get("Mime-Version") != "1.0" {
+		t.Fatalf("got: %v", parsed.Header)
+	}
+	if _, source, _ := parsed.Date(); source != DateSourceHeader {
+		t.Fatalf("got: %d, want: %d", source, DateSourceHeader)
+	}
+
+	alt := parsed.Parts[0]
+	if got := string(alt.Parts[0].Content); got != "Hello\r\nBob" {
+		t.Fatalf("got: %q, want: %q", got, "Hello\r\nBob")
+	}
+	related := alt.Parts[1]
+	image := related.Parts[1]
+	if !bytes.Equal(image.Content, logo) {
+		t.Fatalf("got: %q, want: %q", image.Content, logo)
+	}
+	cid := strings.Trim(image.ContentID, "<>")
+	if !strings.Contains(string(related.Parts[0].Content), `src="cid:`+cid+`"`) {
+		t.Fatalf("got: %s, want cid: %s", related.Parts[0].Content, cid)
+	}
+	attachment := parsed.Parts[1]
+	if attachment.FileName != "报告.txt" || attachment.Disposition != cdAttachment {
+		t.Fatalf("got: %q %q", attachment.FileName, attachment.Disposition)
+	}
+	if got := attachment.Header.Get(hContentEncoding); got != "" {
+		t.Fatalf("got: %s, want: 7bit without a header", got)
+	}
+}
+
+func TestBuilderNoFrom(t *testing.T) {
+	if _, err := NewBuilder().Text("x").Build(); err == nil {
+		t.Fatal("want error")
+	}
+}
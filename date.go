@@ -0,0 +1,287 @@
+package emime
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DateSource tells which header a date was taken from.
+type DateSource int
+
+const (
+	DateSourceNone     DateSource = iota // No usable date found.
+	DateSourceHeader                     // The Date header.
+	DateSourceReceived                   // The newest Received header.
+)
+
+// ErrNoDate is returned by Part.Date when the part has no date headers.
+var ErrNoDate = errors.New("no date found")
+
+var months = map[string]time.Month{
+	"jan": time.January, "feb": time.February, "mar": time.March,
+	"apr": time.April, "may": time.May, "jun": time.June,
+	"jul": time.July, "aug": time.August, "sep": time.September,
+	"oct": time.October, "nov": time.November, "dec": time.December,
+}
+
+// zone abbreviations seen in the wild, offsets in hours
+var zones = map[string]float64{
+	"ut": 0, "utc": 0, "gmt": 0, "z": 0, "wet": 0,
+	"edt": -4, "est": -5, "cdt": -5, "cst": -6, "mdt": -6, "mst": -7, "pdt": -7, "pst": -8,
+	"akdt": -8, "akst": -9, "hst": -10, "adt": -3, "ast": -4, "nst": -3.5, "ndt": -2.5,
+	"bst": 1, "ist": 1, "west": 1, "cet": 1, "met": 1, "mez": 1,
+	"cest": 2, "mest": 2, "mesz": 2, "eet": 2, "sast": 2,
+	"eest": 3, "msk": 3,
+	"hkt": 8, "sgt": 8, "awst": 8, "jst": 9, "kst": 9,
+	"acst": 9.5, "aest": 10, "acdt": 10.5, "aedt": 11, "nzst": 12, "nzdt": 13,
+}
+
+// Date returns the date of the message. When the Date header is missing
+// or unusable, the newest timestamp of the Received headers is used.
+func (p *Part) Date() (time.Time, DateSource, error) {
+	dateErr := ErrNoDate
+	if value := p.Header.Get("Date"); value != "" {
+		t, err := ParseDate(value)
+		if err == nil {
+			return t, DateSourceHeader, nil
+		}
+		dateErr = err
+	}
+	var newest time.Time
+	for _, r := range p.ReceivedChain() {
+		if r.Date.After(newest) {
+			newest = r.Date
+		}
+	}
+	if !newest.IsZero() {
+		return newest, DateSourceReceived, nil
+	}
+	return time.Time{}, DateSourceNone, dateErr
+}
+
+// ParseDate parses an RFC 5322 date-time. It also accepts the broken forms
+// seen in the wild: missing seconds, two and three digit years, named
+// zones, comments, extra or misplaced day names, asctime and ISO 8601
+// dates. A missing or unknown zone is taken as UTC.
+func ParseDate(s string) (time.Time, error) {
+	fields := strings.FieldsFunc(stripComments(unfold(s)), func(r rune) bool {
+		return r == ' ' || r == '\t' || r == ','
+	})
+	d := &dateFields{}
+	for _, field := range fields {
+		if err := d.add(field); err != nil {
+			return time.Time{}, errors.Wrapf(err, "invalid date %q", s)
+		}
+	}
+	if d.day == 0 || d.month == 0 || d.year == 0 {
+		return time.Time{}, errors.Errorf("invalid date %q", s)
+	}
+	if d.loc == nil {
+		d.loc = time.UTC
+	}
+	if time.Date(d.year, d.month, d.day, 0, 0, 0, 0, time.UTC).Day() != d.day {
+		return time.Time{}, errors.Errorf("invalid date %q, day out of range", s)
+	}
+	return time.Date(d.year, d.month, d.day, d.hour, d.min, d.sec, 0, d.loc), nil
+}
+
+// dateFields are the components of a date found so far.
+type dateFields struct {
+	day, year      int
+	month          time.Month
+	hour, min, sec int
+	loc            *time.Location
+}
+
+func (d *dateFields) add(field string) error {
+	lower := strings.ToLower(field)
+	switch c := field[0]; {
+	case isDigits(field):
+		n, _ := strconv.Atoi(field)
+		if d.day == 0 && len(field) <= 2 {
+			if n < 1 || n > 31 {
+				return errors.Errorf("day %q", field)
+			}
+			d.day = n
+			return nil
+		}
+		return d.setYear(field, n)
+	case c >= '0' && c <= '9' && len(field) >= 8 && field[4] == '-':
+		return d.setISO(field)
+	case c >= '0' && c <= '9' && strings.IndexByte(field, ':') > 0:
+		return d.setClock(field)
+	case (c == '+' || c == '-') && len(field) > 1:
+		return d.setOffset(field)
+	case strings.HasPrefix(lower, "gmt+") || strings.HasPrefix(lower, "gmt-") ||
+		strings.HasPrefix(lower, "utc+") || strings.HasPrefix(lower, "utc-"):
+		return d.setOffset(field[3:])
+	}
+	if m, ok := months[prefix(lower, 3)]; ok && len(lower) >= 3 && isMonthName(lower) {
+		if d.month == 0 {
+			d.month = m
+		}
+		return nil
+	}
+	if offset, ok := zones[strings.TrimSuffix(lower, ".")]; ok {
+		if d.loc == nil {
+			d.loc = time.FixedZone(strings.ToUpper(lower), int(offset*3600))
+		}
+		return nil
+	}
+	// day names, military zones and noise words
+	return nil
+}
+
+func (d *dateFields) setYear(field string, n int) error {
+	if d.year > 0 {
+		// e.g. a second year after an asctime date
+		return nil
+	}
+	switch {
+	case len(field) == 2 && n < 50:
+		n += 2000
+	case len(field) <= 3:
+		n += 1900
+	}
+	d.year = n
+	return nil
+}
+
+// setClock parses `hh:mm[:ss[.frac]]` optionally followed by a zone.
+func (d *dateFields) setClock(field string) error {
+	end := strings.IndexFunc(field, func(r rune) bool {
+		return (r < '0' || r > '9') && r != ':' && r != '.'
+	})
+	zone := ""
+	if end >= 0 {
+		field, zone = field[:end], field[end:]
+	}
+	parts := strings.Split(field, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return errors.Errorf("time %q", field)
+	}
+	var nums [3]int
+	for i, part := range parts {
+		if i == 2 {
+			// fractional seconds are dropped
+			part = strings.SplitN(part, ".", 2)[0]
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return errors.Errorf("time %q", field)
+		}
+		nums[i] = n
+	}
+	if nums[0] > 23 || nums[1] > 59 || nums[2] > 60 {
+		return errors.Errorf("time %q", field)
+	}
+	d.hour, d.min, d.sec = nums[0], nums[1], nums[2]
+	if zone == "" {
+		return nil
+	}
+	if offset, ok := zones[strings.ToLower(zone)]; ok {
+		d.loc = time.FixedZone(strings.ToUpper(zone), int(offset*3600))
+		return nil
+	}
+	return d.setOffset(zone)
+}
+
+// setISO parses `yyyy-mm-dd[Thh:mm:ss[zone]]`.
+func (d *dateFields) setISO(field string) error {
+	date, clock := field, ""
+	if i := strings.IndexAny(field, "Tt"); i > 0 {
+		date, clock = field[:i], field[i+1:]
+	}
+	parts := strings.Split(date, "-")
+	if len(parts) != 3 || !isDigits(parts[0]) || !isDigits(parts[1]) || !isDigits(parts[2]) {
+		return errors.Errorf("date %q", field)
+	}
+	year, _ := strconv.Atoi(parts[0])
+	month, _ := strconv.Atoi(parts[1])
+	day, _ := strconv.Atoi(parts[2])
+	if month < 1 || month > 12 || day < 1 || day > 31 {
+		return errors.Errorf("date %q", field)
+	}
+	d.year, d.month, d.day = year, time.Month(month), day
+	if clock != "" {
+		return d.setClock(clock)
+	}
+	return nil
+}
+
+// setOffset parses `+hhmm`, `+hh:mm` or `+h`.
+func (d *dateFields) setOffset(field string) error {
+	if d.loc != nil {
+		// the first zone wins, e.g. `-0700 PDT` or `+0000 +0000`
+		return nil
+	}
+	sign := 1
+	switch field[0] {
+	case '-':
+		sign = -1
+		fallthrough
+	case '+':
+		field = field[1:]
+	}
+	digits := strings.Replace(field, ":", "", 1)
+	if !isDigits(digits) || len(digits) > 4 {
+		return errors.Errorf("zone %q", field)
+	}
+	n, _ := strconv.Atoi(digits)
+	hours, mins := n, 0
+	if len(digits) > 2 {
+		hours, mins = n/100, n%100
+	}
+	if hours > 23 || mins > 59 {
+		return errors.Errorf("zone %q", field)
+	}
+	d.loc = time.FixedZone("", sign*(hours*3600+mins*60))
+	return nil
+}
+
+// isMonthName reports whether s is a prefix of a month name, e.g. `sept`.
+func isMonthName(s string) bool {
+	for m := time.January; m <= time.December; m++ {
+		if strings.HasPrefix(strings.ToLower(m.String()), strings.TrimSuffix(s, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func prefix(s string, n int) string {
+	if len(s) < n {
+		return s
+	}
+	return s[:n]
+}
+
+// stripComments replaces the comments of a header value with a space.
+func stripComments(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] == '(' {
+			i = skipComment(s, i)
+			sb.WriteByte(' ')
+			continue
+		}
+		sb.WriteByte(s[i])
+		i++
+	}
+	return sb.String()
+}
@@ -0,0 +1,72 @@
+package emime
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Mon, 2 Jan 2006 15:04:05 -0700", "2006-01-02T15:04:05-07:00"},
+		{"2 Jan 2006 15:04 +0100", "2006-01-02T15:04:00+01:00"},
+		{"Mon, 02 Jan 06 15:04:05 EST", "2006-01-02T15:04:05-05:00"},
+		{"Sat, 1 Jan 99 00:00:00 GMT", "1999-01-01T00:00:00Z"},
+		{"Tue, 4 Jul 101 12:08:56 +0000", "2001-07-04T12:08:56Z"},
+		{"Thu, 8 Sep 2005 15:00:00 CEST", "2005-09-08T15:00:00+02:00"},
+		{"Wed, 04 Dec 2018 09:12:40 +0000 (GMT)", "2018-12-04T09:12:40Z"},
+		{"Wed, 4 Jul 2001 12:08:56 -0700 PDT", "2001-07-04T12:08:56-07:00"},
+		{"Wednesday, Wed, 4 Jul 2001 12:08:56 -0700", "2001-07-04T12:08:56-07:00"},
+		{"Mon Jan  2 15:04:05 2006", "2006-01-02T15:04:05Z"},
+		{"Mon, 2 Jan 2006 15:04:05 GMT+0800", "2006-01-02T15:04:05+08:00"},
+		{"2 Sept. 2010 10:00:00 +05:30", "2010-09-02T10:00:00+05:30"},
+		{"2006-01-02T15:04:05Z", "2006-01-02T15:04:05Z"},
+		{"Fri,\r\n 12 Mar 2021 08:00:00.123 -0000", "2021-03-12T08:00:00Z"},
+	}
+	for _, tt := range tests {
+		got, err := ParseDate(tt.input)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.input, err)
+		}
+		if got.Format(time.RFC3339) != tt.want {
+			t.Fatalf("%q: got: %s, want: %s", tt.input, got.Format(time.RFC3339), tt.want)
+		}
+	}
+
+	for _, input := range []string{"", "yesterday", "31 Feb 2020 10:00:00", "2 Jan 2006 25:00:00"} {
+		if _, err := ParseDate(input); err == nil {
+			t.Fatalf("%q: want error", input)
+		}
+	}
+}
+
+func TestPartDate(t *testing.T) {
+	received := "Received: from a.example by b.example; Mon, 2 Jan 2006 15:04:05 +0000\r\n" +
+		"Received: from c.example by a.example; Mon, 2 Jan 2006 15:03:00 +0000\r\n"
+	tests := []struct {
+		header string
+		source DateSource
+		want   string
+	}{
+		{"Date: Mon, 2 Jan 2006 10:00:00 +0000\r\n" + received, DateSourceHeader, "2006-01-02T10:00:00Z"},
+		{"Date: garbage\r\n" + received, DateSourceReceived, "2006-01-02T15:04:05Z"},
+		{received, DateSourceReceived, "2006-01-02T15:04:05Z"},
+		{"Subject: none\r\n", DateSourceNone, "0001-01-01T00:00:00Z"},
+	}
+	for _, tt := range tests {
+		root, err := Parse(strings.NewReader(tt.header + "\r\nbody"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, source, err := root.Date()
+		if source != tt.source || got.Format(time.RFC3339) != tt.want {
+			t.Fatalf("got: %s %d, want: %s %d", got.Format(time.RFC3339), source, tt.want, tt.source)
+		}
+		if (source == DateSourceNone) != (err != nil) {
+			t.Fatalf("got: %v", err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package emime
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// DefectKind classifies a problem found while parsing.
+type DefectKind string
+
+// Defect kinds
+const (
+	DefectHeaderMissingName     DefectKind = "HeaderMissingName"     // header line starts with a colon
+	DefectInvalidHeaderLine     DefectKind = "InvalidHeaderLine"     // header line without colon
+	DefectFirstLineContinuation DefectKind = "FirstLineContinuation" // first header line is indented
+	DefectMalformedMediaType    DefectKind = "MalformedMediaType"    // Content-Type had to be fixed
+	DefectMalformedDisposition  DefectKind = "MalformedDisposition"  // Content-Disposition is ignored
+	DefectUnknownEncoding       DefectKind = "UnknownEncoding"       // unknown Content-Transfer-Encoding
+	DefectCorruptContent        DefectKind = "CorruptContent"        // body failed to decode
+	DefectInvalidBase64Chars    DefectKind = "InvalidBase64Chars"    // invalid characters stripped from base64
+	DefectMissingBoundary       DefectKind = "MissingBoundary"       // multipart without boundary parameter
+	DefectStartBoundaryNotFound DefectKind = "StartBoundaryNotFound" // multipart without any part
+	DefectCloseBoundaryNotFound DefectKind = "CloseBoundaryNotFound" // multipart without close delimiter
+	DefectMalformedMessage      DefectKind = "MalformedMessage"      // encapsulated message is dropped
+	DefectUnknownCharset        DefectKind = "UnknownCharset"        // text content is kept undecoded
+
+	// Checked in strict mode only.
+	DefectBareCR           DefectKind = "BareCR"           // CR not followed by LF
+	DefectBareLF           DefectKind = "BareLF"           // LF not preceded by CR
+	DefectLineTooLong      DefectKind = "LineTooLong"      // line longer than 998 octets
+	DefectNonASCIIHeader   DefectKind = "NonASCIIHeader"   // 8-bit header without SMTPUTF8
+	DefectInvalidEncoding  DefectKind = "InvalidEncoding"  // content does not match its encoding
+	DefectInvalidDelimiter DefectKind = "InvalidDelimiter" // malformed boundary delimiter line
+)
+
+// Defect is a problem found while parsing, parsing recovers from it.
+type Defect struct {
+	Kind    DefectKind
+	PartID  string
+	Offset  int64 // Byte offset in the message.
+	Message string
+}
+
+func (d *Defect) String() string {
+	return fmt.Sprintf("%s at offset %d, part %q: %s", d.Kind, d.Offset, d.PartID, d.Message)
+}
+
+// DefectError is returned in strict mode for the first defect found.
+type DefectError struct {
+	Defect *Defect
+
+	attached bool // Defect is recorded on a part
+}
+
+func (e *DefectError) Error() string {
+	return "strict: " + e.Defect.String()
+}
+
+func isDefectError(err error) bool {
+	var derr *DefectError
+	return errors.As(err, &derr)
+}
+
+// defect records a defect on p, which is returned as an error in strict mode.
+func (p *Part) defect(opts *ParseOptions, kind DefectKind, offset int64, format string, args ...interface{}) error {
+	p.addDefect(kind, offset, format, args...)
+	if opts.Strict {
+		return &DefectError{Defect: p.Defects[len(p.Defects)-1], attached: true}
+	}
+	return nil
+}
+
+// attachDefect records the defect of a DefectError raised below the part
+// level, e.g. by the line checker, on p.
+func attachDefect(p *Part, err error) {
+	var derr *DefectError
+	if errors.As(err, &derr) && !derr.attached {
+		derr.attached = true
+		derr.Defect.PartID = p.PartID
+		p.Defects = append(p.Defects, derr.Defect)
+	}
+}
+
+func (p *Part) addDefect(kind DefectKind, offset int64, format string, args ...interface{}) {
+	p.Defects = append(p.Defects, &Defect{
+		Kind:    kind,
+		PartID:  p.PartID,
+		Offset:  offset,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// AllDefects returns the defects of p and all its descendants.
+func (p *Part) AllDefects() []*Defect {
+	defects := append([]*Defect(nil), p.Defects...)
+	for _, child := range p.Parts {
+		defects = append(defects, child.AllDefects()...)
+	}
+	return defects
+}
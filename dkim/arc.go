@@ -0,0 +1,342 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/daogan/emime"
+	"github.com/pkg/errors"
+)
+
+const (
+	hARCSeal             = "ARC-Seal"
+	hARCMessageSignature = "ARC-Message-Signature"
+	hARCAuthResults      = "ARC-Authentication-Results"
+
+	// maxARCInstances is the limit of ARC sets, RFC 8617 4.2.1.
+	maxARCInstances = 50
+)
+
+// None is the chain validation result of a message without ARC sets.
+const None Result = "none"
+
+// ARCResult is the validation result of an Authenticated Received Chain,
+// RFC 8617.
+type ARCResult struct {
+	Result    Result // cv=: None, Pass or Fail.
+	Instances int    // Number of ARC sets.
+	// FailedInstance is the instance which failed, 0 if none did.
+	FailedInstance int
+	Err            error // Why the chain failed.
+}
+
+// arcSet is one instance of the chain.
+type arcSet struct {
+	aar, ams, seal *field
+}
+
+// VerifyARC validates the ARC chain of the message p, keys are looked up
+// with r.
+func VerifyARC(p *emime.Part, r Resolver) (*ARCResult, error) {
+	raw := p.Raw()
+	if raw == nil {
+		return nil, errors.New("dkim: original bytes of the message not kept")
+	}
+	fields, body := splitMessage(raw)
+	return verifyChain(fields, body, r), nil
+}
+
+func verifyChain(fields []field, body []byte, r Resolver) *ARCResult {
+	sets, err := arcSets(fields)
+	res := &ARCResult{Instances: len(sets)}
+	fail := func(instance int, err error) *ARCResult {
+		res.Result, res.FailedInstance, res.Err = Fail, instance, errors.Wrap(err, "arc")
+		return res
+	}
+	if err != nil {
+		return fail(0, err)
+	}
+	n := len(sets)
+	if n == 0 {
+		res.Result = None
+		return res
+	}
+	for i := 1; i <= n; i++ {
+		tags, err := parseTags(sets[i].seal.value())
+		if err != nil {
+			return fail(i, err)
+		}
+		want := Pass
+		if i == 1 {
+			want = None
+		}
+		if cv := Result(strings.ToLower(tags["cv"])); cv != want {
+			return fail(i, errors.Errorf("cv=%s in instance %d", cv, i))
+		}
+	}
+	// the newest message signature, then all seals from the newest
+	if v := verify(*sets[n].ams, fields, body, r, true); v.Result != Pass {
+		return fail(n, v.Err)
+	}
+	for i := n; i >= 1; i-- {
+		if err := verifySeal(sets, i, r); err != nil {
+			return fail(i, err)
+		}
+	}
+	res.Result = Pass
+	return res
+}
+
+// arcSets returns the ARC sets of fields by instance, they must be
+// complete and numbered from 1.
+func arcSets(fields []field) (map[int]*arcSet, error) {
+	sets := make(map[int]*arcSet)
+	for i := range fields {
+		f := &fields[i]
+		var slot **field
+		var instance int
+		var err error
+		switch {
+		case strings.EqualFold(f.name, hARCSeal), strings.EqualFold(f.name, hARCMessageSignature):
+			tags, perr := parseTags(f.value())
+			if perr != nil {
+				return nil, errors.Wrapf(perr, "%s", f.name)
+			}
+			instance, err = strconv.Atoi(tags["i"])
+		case strings.EqualFold(f.name, hARCAuthResults):
+			// i=1; authserv-id; results
+			value := strings.TrimSpace(f.value())
+			end := strings.IndexByte(value, ';')
+			if end < 0 || !strings.HasPrefix(value, "i=") {
+				return nil, errors.Errorf("malformed %s", f.name)
+			}
+			instance, err = strconv.Atoi(strings.TrimSpace(value[2:end]))
+		default:
+			continue
+		}
+		if err != nil || instance < 1 || instance > maxARCInstances {
+			return nil, errors.Errorf("invalid instance in %s", f.name)
+		}
+		set := sets[instance]
+		if set == nil {
+			set = &arcSet{}
+			sets[instance] = set
+		}
+		switch {
+		case strings.EqualFold(f.name, hARCSeal):
+			slot = &set.seal
+		case strings.EqualFold(f.name, hARCMessageSignature):
+			slot = &set.ams
+		default:
+			slot = &set.aar
+		}
+		if *slot != nil {
+			return nil, errors.Errorf("duplicate %s of instance %d", f.name, instance)
+		}
+		*slot = f
+	}
+	for i := 1; i <= len(sets); i++ {
+		set := sets[i]
+		if set == nil || set.aar == nil || set.ams == nil || set.seal == nil {
+			return nil, errors.Errorf("incomplete ARC set %d", i)
+		}
+	}
+	return sets, nil
+}
+
+// sealedFields returns the fields an ARC-Seal of instance signs, the sets
+// up to the instance, without the seal itself.
+func sealedFields(sets map[int]*arcSet, instance int) []field {
+	var fields []field
+	for i := 1; i <= instance; i++ {
+		fields = append(fields, *sets[i].aar, *sets[i].ams)
+		if i < instance {
+			fields = append(fields, *sets[i].seal)
+		}
+	}
+	return fields
+}
+
+func verifySeal(sets map[int]*arcSet, instance int, r Resolver) error {
+	seal := sets[instance].seal
+	tags, err := parseTags(seal.value())
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"a", "b", "d", "s", "cv"} {
+		if tags[name] == "" {
+			return errors.Errorf("missing tag %s= in seal %d", name, instance)
+		}
+	}
+	if _, ok := tags["h"]; ok {
+		return errors.Errorf("h= in seal %d", instance)
+	}
+	v := &Verification{
+		Domain:    strings.ToLower(tags["d"]),
+		Selector:  tags["s"],
+		Algorithm: strings.ToLower(tags["a"]),
+	}
+	v.Identifier = "@" + v.Domain
+	if v.Algorithm != RSASHA256 && v.Algorithm != Ed25519SHA256 {
+		return errors.Errorf("unsupported algorithm %q", v.Algorithm)
+	}
+	signature, err := base64.StdEncoding.DecodeString(stripSpace(tags["b"]))
+	if err != nil {
+		return errors.Wrap(err, "invalid signature")
+	}
+	key, _, err := v.lookupKey(r)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	for _, f := range sealedFields(sets, instance) {
+		h.Write([]byte(canonicalHeader(f, Relaxed)))
+	}
+	unsigned := field{name: seal.name, raw: removeSignature(seal.raw)}
+	h.Write([]byte(strings.TrimSuffix(canonicalHeader(unsigned, Relaxed), "\r\n")))
+	return checkSignature(key, h.Sum(nil), signature)
+}
+
+// SealOptions configures the ARC set of EncodeARC.
+type SealOptions struct {
+	Domain   string // d=, the sealing domain.
+	Selector string // s=
+	// Signer is the private key, an *rsa.PrivateKey or ed25519.PrivateKey.
+	Signer crypto.Signer
+	// AuthServID and AuthResults are the authentication results of the
+	// sealer, e.g. "mx.example.com" and "spf=pass smtp.mailfrom=example.net".
+	AuthServID  string
+	AuthResults string
+	// HeaderKeys are the header fields of the ARC-Message-Signature, the
+	// common fields of the message by default.
+	HeaderKeys []string
+	// Time is the sealing time, now by default.
+	Time time.Time
+	// ChainValidation is the result of VerifyARC on arrival, before the
+	// message was modified. If empty, the chain is validated by Resolver.
+	ChainValidation Result
+	Resolver        Resolver
+	// EncodeOptions are passed to Part.EncodeWithOptions.
+	EncodeOptions *emime.EncodeOptions
+}
+
+// EncodeARC encodes p to w with a new ARC set added on top, sealing the
+// validation result of the existing chain. Line breaks are made CRLF.
+//
+// A relay which modifies the message validates the chain first:
+//
+//	res, _ := dkim.VerifyARC(root, dkim.DNSResolver)
+//	// modify root
+//	err := dkim.EncodeARC(w, root, &dkim.SealOptions{ChainValidation: res.Result, ...})
+func EncodeARC(w io.Writer, p *emime.Part, opts *SealOptions) error {
+	if opts == nil {
+		return errMissingOptions
+	}
+	buf := &bytes.Buffer{}
+	if err := p.EncodeWithOptions(buf, opts.EncodeOptions); err != nil {
+		return err
+	}
+	msg := crlf(buf.Bytes())
+	header, err := Seal(msg, opts)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+	_, err = w.Write(msg)
+	return err
+}
+
+// Seal returns the header fields of a new ARC set of msg, with their line
+// breaks, to be prepended to msg. msg must have CRLF line breaks. A chain
+// which already failed is not sealed again.
+func Seal(msg []byte, opts *SealOptions) (string, error) {
+	if opts == nil {
+		return "", errMissingOptions
+	}
+	alg, _, err := signAlgorithm(opts.Signer)
+	if err != nil {
+		return "", err
+	}
+	if opts.Domain == "" || opts.Selector == "" || opts.AuthServID == "" {
+		return "", errors.New("arc: missing domain, selector or authserv-id")
+	}
+	if opts.ChainValidation == "" && opts.Resolver == nil {
+		return "", errors.New("arc: missing resolver")
+	}
+	fields, body := splitMessage(msg)
+	sets, err := arcSets(fields)
+	if err != nil {
+		return "", errors.Wrap(err, "arc: malformed chain")
+	}
+	if n := len(sets); n > 0 {
+		if tags, err := parseTags(sets[n].seal.value()); err == nil && strings.EqualFold(tags["cv"], string(Fail)) {
+			return "", errors.New("arc: chain already failed")
+		}
+	}
+	cv := opts.ChainValidation
+	if cv == "" {
+		cv = verifyChain(fields, body, opts.Resolver).Result
+	}
+	// the first instance has cv=none, RFC 8617 5.1.1
+	if (cv == None) != (len(sets) == 0) || cv != None && cv != Pass && cv != Fail {
+		return "", errors.Errorf("arc: invalid chain validation %q", cv)
+	}
+	instance := len(sets) + 1
+	if instance > maxARCInstances {
+		return "", errors.Errorf("arc: more than %d instances", maxARCInstances)
+	}
+	t := opts.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	i := "i=" + strconv.Itoa(instance)
+	ts := "t=" + strconv.FormatInt(t.Unix(), 10)
+
+	results := strings.TrimSpace(opts.AuthResults)
+	if results == "" {
+		results = "none"
+	}
+	aar := foldTags(hARCAuthResults+":", []string{i, opts.AuthServID, results}) + "\r\n"
+
+	keys := opts.HeaderKeys
+	if keys == nil {
+		for _, k := range append(defaultHeaderKeys, hDKIMSignature) {
+			if len(selectHeaders(fields, []string{k})) > 0 {
+				keys = append(keys, k)
+			}
+		}
+	}
+	for _, k := range keys {
+		if strings.EqualFold(k, hARCSeal) {
+			return "", errors.New("arc: ARC-Seal must not be signed")
+		}
+	}
+	bodyHash := sha256.Sum256(canonicalBody(body, Relaxed))
+	ams, err := signFields(hARCMessageSignature, []string{
+		i, "a=" + alg, "c=relaxed/relaxed", "d=" + opts.Domain, "s=" + opts.Selector, ts,
+		"h=" + strings.ToLower(strings.Join(keys, ":")),
+		"bh=" + base64.StdEncoding.EncodeToString(bodyHash[:]),
+	}, selectHeaders(fields, keys), Relaxed, opts.Signer)
+	if err != nil {
+		return "", err
+	}
+
+	// the new set joins the chain for the seal
+	newFields, _ := splitMessage([]byte(aar + ams + "\r\n"))
+	sets[instance] = &arcSet{aar: &newFields[0], ams: &newFields[1]}
+	seal, err := signFields(hARCSeal, []string{
+		i, "a=" + alg, "cv=" + string(cv), "d=" + opts.Domain, "s=" + opts.Selector, ts,
+	}, sealedFields(sets, instance), Relaxed, opts.Signer)
+	if err != nil {
+		return "", err
+	}
+	return seal + ams + aar, nil
+}
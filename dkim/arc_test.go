@@ -0,0 +1,122 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/daogan/emime"
+)
+
+func TestARC(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPub, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := MapResolver{
+		"arc._domainkey.relay.example": "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(rsaPub),
+		"arc._domainkey.list.example":  "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edPub),
+	}
+
+	root, err := emime.NewBuilder().
+		From(&emime.Address{Address: "alice@example.com"}).
+		To(&emime.Address{Address: "list@list.example"}).
+		Subject("ARC").
+		Text("Hello list\n").
+		Attach("data.bin", "application/octet-stream", []byte{1, 2, 3}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := VerifyARC(root, keys)
+	if err == nil {
+		t.Fatal("want error without original bytes")
+	}
+	buf := &bytes.Buffer{}
+	if err := root.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	check := func(msg string, result Result, instances, failed int) *emime.Part {
+		t.Helper()
+		p, err := emime.ParseWithOptions(strings.NewReader(msg), &emime.ParseOptions{KeepRaw: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err = VerifyARC(p, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Result != result || res.Instances != instances || res.FailedInstance != failed {
+			t.Fatalf("got: %+v, want: %s %d %d\n%s", res, result, instances, failed, msg)
+		}
+		return p
+	}
+	p := check(buf.String(), None, 0, 0)
+
+	if err := EncodeARC(&bytes.Buffer{}, p, nil); err == nil {
+		t.Fatal("want error without options")
+	}
+	if _, err := Seal(buf.Bytes(), nil); err == nil {
+		t.Fatal("want error without options")
+	}
+
+	// the first hop seals, cv=none
+	if err := EncodeARC(&bytes.Buffer{}, p, &SealOptions{Domain: "relay.example", Selector: "arc", Signer: rsaKey,
+		AuthServID: "mx.relay.example", ChainValidation: Pass}); err == nil {
+		t.Fatal("want error for cv=pass without a chain")
+	}
+	buf.Reset()
+	if err := EncodeARC(buf, p, &SealOptions{Domain: "relay.example", Selector: "arc", Signer: rsaKey,
+		AuthServID: "mx.relay.example", AuthResults: "spf=pass smtp.mailfrom=example.com", Resolver: keys}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "cv=none") {
+		t.Fatalf("got: %s, want: cv=none", buf)
+	}
+	p = check(buf.String(), Pass, 1, 0)
+
+	// the list validates, adds a footer and seals, cv=pass
+	list := &SealOptions{Domain: "list.example", Selector: "arc", Signer: edKey,
+		AuthServID: "list.example", AuthResults: "arc=pass", ChainValidation: res.Result}
+	p.Parts[0].Content = append(p.Parts[0].Content, "-- \r\nlist footer\r\n"...)
+	buf.Reset()
+	if err := EncodeARC(buf, p, list); err != nil {
+		t.Fatal(err)
+	}
+	sealed := buf.String()
+	if !strings.Contains(sealed, "list footer") || !strings.HasPrefix(sealed, "ARC-Seal: i=2;") {
+		t.Fatalf("got: %s", sealed)
+	}
+	check(sealed, Pass, 2, 0)
+
+	// the body changed after the last seal
+	check(strings.Replace(sealed, "list footer", "LIST FOOTER", 1), Fail, 2, 2)
+	// the first set changed, which the newest seal covers
+	check(strings.Replace(sealed, "spf=pass", "spf=fail", 1), Fail, 2, 2)
+	// an incomplete chain
+	check(sealed[strings.Index(sealed, "ARC-Message-Signature:"):], Fail, 0, 0)
+
+	// a failed validation is sealed once
+	list.ChainValidation = Fail
+	buf.Reset()
+	if err := EncodeARC(buf, p, list); err != nil {
+		t.Fatal(err)
+	}
+	p = check(buf.String(), Fail, 2, 2)
+	if err := EncodeARC(&bytes.Buffer{}, p, list); err == nil {
+		t.Fatal("want error sealing a failed chain")
+	}
+}
@@ -0,0 +1,246 @@
+// Package dkim verifies and creates DKIM signatures, RFC 6376, of messages
+// parsed by emime.
+//
+//	root, _ := emime.ParseWithOptions(r, &emime.ParseOptions{KeepRaw: true})
+//	results, err := dkim.Verify(root, dkim.DNSResolver)
+//
+// Signatures are verified on the original bytes of the message, it must
+// be parsed with emime.ParseOptions.KeepRaw.
+package dkim
+
+import (
+	"bytes"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const hDKIMSignature = "DKIM-Signature"
+
+// Canonicalization algorithms.
+const (
+	Simple  = "simple"
+	Relaxed = "relaxed"
+)
+
+// Signing algorithms.
+const (
+	RSASHA256     = "rsa-sha256"
+	Ed25519SHA256 = "ed25519-sha256"
+)
+
+// Result is the result of a signature check, named like in
+// Authentication-Results, RFC 8601.
+type Result string
+
+const (
+	Pass      Result = "pass"
+	Fail      Result = "fail"      // The signature or body hash does not match.
+	PermError Result = "permerror" // The signature or key is unusable.
+	TempError Result = "temperror" // The key could not be looked up.
+)
+
+// Resolver looks up the TXT records of DKIM keys, it is usually DNS.
+// Tests may use a map of records.
+type Resolver interface {
+	LookupTXT(name string) ([]string, error)
+}
+
+// ErrKeyNotFound is returned by a Resolver when no record exists.
+var ErrKeyNotFound = errors.New("dkim: key not found")
+
+type dnsResolver struct{}
+
+func (dnsResolver) LookupTXT(name string) ([]string, error) {
+	txts, err := net.LookupTXT(name)
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		return nil, ErrKeyNotFound
+	}
+	return txts, err
+}
+
+// DNSResolver looks up keys in DNS.
+var DNSResolver Resolver = dnsResolver{}
+
+// MapResolver is an in-memory Resolver of records by name, e.g.
+// "selector._domainkey.example.com".
+type MapResolver map[string]string
+
+func (m MapResolver) LookupTXT(name string) ([]string, error) {
+	txt, ok := m[strings.ToLower(name)]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return []string{txt}, nil
+}
+
+// field is a header field, raw includes the folding and line break.
+type field struct {
+	name string
+	raw  string
+}
+
+func (f field) value() string {
+	return f.raw[strings.IndexByte(f.raw, ':')+1:]
+}
+
+// splitMessage returns the header fields and the body of msg, line breaks
+// are made CRLF.
+func splitMessage(msg []byte) ([]field, []byte) {
+	msg = crlf(msg)
+	var fields []field
+	for len(msg) > 0 {
+		if bytes.HasPrefix(msg, []byte("\r\n")) {
+			return fields, msg[2:]
+		}
+		end := 0
+		for {
+			i := bytes.Index(msg[end:], []byte("\r\n"))
+			if i < 0 {
+				end = len(msg)
+				break
+			}
+			end += i + 2
+			if end == len(msg) || (msg[end] != ' ' && msg[end] != '\t') {
+				break
+			}
+		}
+		line := string(msg[:end])
+		if i := strings.IndexByte(line, ':'); i > 0 {
+			fields = append(fields, field{name: strings.TrimRight(line[:i], " \t"), raw: line})
+		}
+		msg = msg[end:]
+	}
+	return fields, nil
+}
+
+// crlf makes bare LF line breaks CRLF.
+func crlf(b []byte) []byte {
+	if bytes.Count(b, []byte("\n")) == bytes.Count(b, []byte("\r\n")) {
+		return b
+	}
+	out := make([]byte, 0, len(b)+bytes.Count(b, []byte("\n")))
+	for i, c := range b {
+		if c == '\n' && (i == 0 || b[i-1] != '\r') {
+			out = append(out, '\r')
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// parseTags parses a tag list, `a=rsa-sha256; d=example.com`, folding
+// whitespace is removed.
+func parseTags(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, spec := range strings.Split(s, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		i := strings.IndexByte(spec, '=')
+		if i <= 0 {
+			return nil, errors.Errorf("malformed tag %q", spec)
+		}
+		name := strings.TrimSpace(spec[:i])
+		if _, ok := tags[name]; ok {
+			return nil, errors.Errorf("duplicate tag %q", name)
+		}
+		tags[name] = strings.TrimSpace(spec[i+1:])
+	}
+	return tags, nil
+}
+
+var foldingSpace = regexp.MustCompile(`[ \t\r\n]+`)
+
+// stripSpace removes all whitespace, e.g. of base64 values.
+func stripSpace(s string) string {
+	return foldingSpace.ReplaceAllString(s, "")
+}
+
+// canonicalHeader returns the canonical form of f.
+func canonicalHeader(f field, canon string) string {
+	if canon == Simple {
+		return f.raw
+	}
+	value := strings.Replace(f.value(), "\r\n", "", -1)
+	value = strings.TrimSpace(foldingSpace.ReplaceAllString(value, " "))
+	return strings.ToLower(f.name) + ":" + value + "\r\n"
+}
+
+// canonicalBody returns the canonical form of body.
+func canonicalBody(body []byte, canon string) []byte {
+	if canon == Relaxed {
+		lines := bytes.SplitAfter(body, []byte("\r\n"))
+		buf := make([]byte, 0, len(body))
+		for _, line := range lines {
+			text := bytes.TrimSuffix(line, []byte("\r\n"))
+			text = foldingSpace.ReplaceAll(text, []byte(" "))
+			text = bytes.TrimRight(text, " ")
+			buf = append(buf, text...)
+			if len(text) < len(line) {
+				buf = append(buf, '\r', '\n')
+			}
+		}
+		body = buf
+	}
+	// trailing empty lines are ignored
+	for bytes.HasSuffix(body, []byte("\r\n\r\n")) {
+		body = body[:len(body)-2]
+	}
+	if len(body) > 0 && !bytes.HasSuffix(body, []byte("\r\n")) {
+		body = append(body[:len(body):len(body)], '\r', '\n')
+	}
+	if canon == Simple && len(body) == 0 {
+		return []byte("\r\n")
+	}
+	if canon == Relaxed && bytes.Equal(body, []byte("\r\n")) {
+		return nil
+	}
+	return body
+}
+
+// parseCanonicalization parses the c= tag, `header/body`.
+func parseCanonicalization(c string) (header, body string, err error) {
+	header, body = Simple, Simple
+	if c != "" {
+		parts := strings.SplitN(strings.ToLower(c), "/", 2)
+		header = parts[0]
+		if len(parts) == 2 {
+			body = parts[1]
+		}
+	}
+	for _, canon := range []string{header, body} {
+		if canon != Simple && canon != Relaxed {
+			return "", "", errors.Errorf("unknown canonicalization %q", c)
+		}
+	}
+	return header, body, nil
+}
+
+var signatureValue = regexp.MustCompile(`(^|;)([ \t\r\n]*b[ \t\r\n]*=)[^;]*`)
+
+// removeSignature empties the b= tag of a signature field.
+func removeSignature(raw string) string {
+	i := strings.IndexByte(raw, ':') + 1
+	return raw[:i] + signatureValue.ReplaceAllString(raw[i:], "$1$2")
+}
+
+// selectHeaders returns the fields named by h in signing order, the last
+// unused instance of each name, names without instances are skipped.
+func selectHeaders(fields []field, h []string) []field {
+	used := make(map[int]bool)
+	var selected []field
+	for _, name := range h {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(fields[i].name, name) {
+				used[i] = true
+				selected = append(selected, fields[i])
+				break
+			}
+		}
+	}
+	return selected
+}
@@ -0,0 +1,205 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/daogan/emime"
+	"github.com/pkg/errors"
+)
+
+// headers signed by default, if present
+var defaultHeaderKeys = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID",
+	"In-Reply-To", "References", "MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+}
+
+var errMissingOptions = errors.New("dkim: missing options")
+
+// SignOptions configures the signature of Encode.
+type SignOptions struct {
+	Domain     string // d=, the signing domain.
+	Selector   string // s=, the key is published at Selector._domainkey.Domain.
+	Identifier string // i=, optional.
+	// Signer is the private key, an *rsa.PrivateKey or ed25519.PrivateKey.
+	Signer crypto.Signer
+	// HeaderKeys are the header fields to sign, From is required. By
+	// default the common fields of the message are signed. A name may
+	// be listed more often than it occurs, to prevent adding fields.
+	HeaderKeys []string
+	// Canonicalizations, Relaxed by default.
+	HeaderCanonicalization string
+	BodyCanonicalization   string
+	// Time is the signing time, now by default.
+	Time time.Time
+	// Expiration is the validity of the signature, no expiry if zero.
+	Expiration time.Duration
+	// EncodeOptions are passed to Part.EncodeWithOptions.
+	EncodeOptions *emime.EncodeOptions
+}
+
+// Encode encodes p to w with a DKIM-Signature header added on top. The
+// signature covers the bytes written, line breaks are made CRLF.
+func Encode(w io.Writer, p *emime.Part, opts *SignOptions) error {
+	if opts == nil {
+		return errMissingOptions
+	}
+	buf := &bytes.Buffer{}
+	if err := p.EncodeWithOptions(buf, opts.EncodeOptions); err != nil {
+		return err
+	}
+	msg := crlf(buf.Bytes())
+	header, err := Sign(msg, opts)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+	_, err = w.Write(msg)
+	return err
+}
+
+// Sign returns the DKIM-Signature header field of msg, with its line
+// break, to be prepended to msg. msg must have CRLF line breaks.
+func Sign(msg []byte, opts *SignOptions) (string, error) {
+	if opts == nil {
+		return "", errMissingOptions
+	}
+	alg, _, err := signAlgorithm(opts.Signer)
+	if err != nil {
+		return "", err
+	}
+	if opts.Domain == "" || opts.Selector == "" {
+		return "", errors.New("dkim: missing domain or selector")
+	}
+	headerCanon, bodyCanon := opts.HeaderCanonicalization, opts.BodyCanonicalization
+	if headerCanon == "" {
+		headerCanon = Relaxed
+	}
+	if bodyCanon == "" {
+		bodyCanon = Relaxed
+	}
+	if _, _, err := parseCanonicalization(headerCanon + "/" + bodyCanon); err != nil {
+		return "", errors.Wrap(err, "dkim")
+	}
+
+	fields, body := splitMessage(msg)
+	keys := opts.HeaderKeys
+	if keys == nil {
+		for _, k := range defaultHeaderKeys {
+			if len(selectHeaders(fields, []string{k})) > 0 {
+				keys = append(keys, k)
+			}
+		}
+	}
+	signsFrom := false
+	for _, k := range keys {
+		signsFrom = signsFrom || strings.EqualFold(k, "From")
+	}
+	if !signsFrom {
+		return "", errors.New("dkim: From must be signed")
+	}
+
+	bodyHash := sha256.Sum256(canonicalBody(body, bodyCanon))
+	t := opts.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	tags := []string{
+		"v=1", "a=" + alg, "c=" + headerCanon + "/" + bodyCanon,
+		"d=" + opts.Domain, "s=" + opts.Selector,
+	}
+	if opts.Identifier != "" {
+		tags = append(tags, "i="+opts.Identifier)
+	}
+	tags = append(tags, "t="+strconv.FormatInt(t.Unix(), 10))
+	if opts.Expiration > 0 {
+		tags = append(tags, "x="+strconv.FormatInt(t.Add(opts.Expiration).Unix(), 10))
+	}
+	tags = append(tags, "h="+strings.ToLower(strings.Join(keys, ":")),
+		"bh="+base64.StdEncoding.EncodeToString(bodyHash[:]))
+	return signFields(hDKIMSignature, tags, selectHeaders(fields, keys), headerCanon, opts.Signer)
+}
+
+// signAlgorithm returns the algorithm of signer.
+func signAlgorithm(signer crypto.Signer) (string, crypto.SignerOpts, error) {
+	switch signer.(type) {
+	case *rsa.PrivateKey:
+		return RSASHA256, crypto.SHA256, nil
+	case ed25519.PrivateKey:
+		return Ed25519SHA256, crypto.Hash(0), nil
+	}
+	return "", nil, errors.Errorf("dkim: unsupported key %T", signer)
+}
+
+// signFields returns the signature header field name of tags, which
+// signs the signed fields and itself.
+func signFields(name string, tags []string, signed []field, canon string, signer crypto.Signer) (string, error) {
+	_, hashOpt, err := signAlgorithm(signer)
+	if err != nil {
+		return "", err
+	}
+	template := foldTags(name+":", append(tags, "b="))
+	h := sha256.New()
+	for _, f := range signed {
+		h.Write([]byte(canonicalHeader(f, canon)))
+	}
+	unsigned := field{name: name, raw: removeSignature(template + "\r\n")}
+	h.Write([]byte(strings.TrimSuffix(canonicalHeader(unsigned, canon), "\r\n")))
+	signature, err := signer.Sign(rand.Reader, h.Sum(nil), hashOpt)
+	if err != nil {
+		return "", errors.Wrap(err, "dkim")
+	}
+	lastLine := template[strings.LastIndexByte(template, '\n')+1:]
+	return template + foldValue(base64.StdEncoding.EncodeToString(signature), len(lastLine)) + "\r\n", nil
+}
+
+// maxLineLength is the folding width of the signature field.
+const maxLineLength = 76
+
+// foldTags joins tags into a header field, folded between tags.
+func foldTags(name string, tags []string) string {
+	s, lineLen := name, len(name)
+	for i, tag := range tags {
+		if i < len(tags)-1 {
+			tag += ";"
+		}
+		if lineLen+1+len(tag) > maxLineLength && lineLen > len(name) {
+			s += "\r\n"
+			lineLen = 0
+		}
+		s += " " + tag
+		lineLen += 1 + len(tag)
+	}
+	return s
+}
+
+// foldValue folds a base64 value which follows a line of lineLen bytes.
+func foldValue(value string, lineLen int) string {
+	s := ""
+	for len(value) > 0 {
+		n := maxLineLength - lineLen
+		if n <= 0 {
+			s += "\r\n "
+			lineLen = 1
+			continue
+		}
+		if n > len(value) {
+			n = len(value)
+		}
+		s += value[:n]
+		value = value[n:]
+		lineLen += n
+	}
+	return s
+}
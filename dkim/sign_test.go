@@ -0,0 +1,98 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/daogan/emime"
+)
+
+func TestEncodeSign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPub, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := MapResolver{
+		"rsa._domainkey.example.com": "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(rsaPub),
+		"ed._domainkey.example.com":  "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edPub),
+	}
+
+	root, err := emime.NewBuilder().
+		From(&emime.Address{Name: "Alice", Address: "alice@example.com"}).
+		To(&emime.Address{Address: "bob@example.com"}).
+		Subject("Grüße aus Köln, mit einem recht langen Betreff der gefaltet wird").
+		Text("Hallo Bob,\nbis bald.   \n\n\n").
+		Attach("data.bin", "application/octet-stream", bytes.Repeat([]byte{0xff, 0}, 100)).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []SignOptions{
+		{Selector: "rsa", Signer: rsaKey},
+		{Selector: "ed", Signer: edKey, HeaderCanonicalization: Simple, BodyCanonicalization: Simple},
+		{Selector: "rsa", Signer: rsaKey, Identifier: "alice@mail.example.com",
+			HeaderKeys: []string{"From", "From", "Subject"}, HeaderCanonicalization: Simple},
+	}
+	for _, opts := range tests {
+		opts.Domain = "example.com"
+		buf := &bytes.Buffer{}
+		if err := Encode(buf, root, &opts); err != nil {
+			t.Fatal(err)
+		}
+		msg := buf.String()
+		signed, err := emime.ParseWithOptions(strings.NewReader(msg), &emime.ParseOptions{KeepRaw: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := Verify(signed, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || results[0].Result != Pass {
+			t.Fatalf("got: %+v\n%s", results[0], msg)
+		}
+		for _, line := range strings.Split(msg[:strings.Index(msg, "From:")], "\r\n") {
+			if len(line) > 78 {
+				t.Fatalf("got: line of %d bytes: %q", len(line), line)
+			}
+		}
+
+		// a From field added after signing
+		if opts.HeaderKeys != nil {
+			forged, err := emime.ParseWithOptions(strings.NewReader(strings.Replace(msg, "From:", "From: eve@example.net\r\nFrom:", 1)), &emime.ParseOptions{KeepRaw: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			results, err := Verify(forged, keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if results[0].Result != Fail {
+				t.Fatalf("got: %s, want: %s", results[0].Result, Fail)
+			}
+		}
+	}
+
+	if err := Encode(&bytes.Buffer{}, root, &SignOptions{Domain: "example.com", Selector: "rsa",
+		Signer: rsaKey, HeaderKeys: []string{"Subject"}}); err == nil {
+		t.Fatal("want error without From")
+	}
+	if err := Encode(&bytes.Buffer{}, root, nil); err == nil {
+		t.Fatal("want error without options")
+	}
+}
@@ -0,0 +1,267 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/daogan/emime"
+	"github.com/pkg/errors"
+)
+
+// Verification is the result of checking one DKIM signature.
+type Verification struct {
+	Domain     string   // d=, the signing domain.
+	Selector   string   // s=
+	Identifier string   // i=, the agent or user identifier, @Domain by default.
+	Algorithm  string   // a=
+	HeaderKeys []string // h=, the signed header fields.
+	BodyLength int64    // l=, -1 if the whole body is signed.
+	Time       time.Time
+	Expiration time.Time // Zero if the signature does not expire.
+	Result     Result
+	Err        error // Why the result is not Pass.
+}
+
+// Verify checks every DKIM-Signature of the message p, keys are looked up
+// with r. The results are in header order, an empty result means the
+// message is not signed.
+func Verify(p *emime.Part, r Resolver) ([]*Verification, error) {
+	raw := p.Raw()
+	if raw == nil {
+		return nil, errors.New("dkim: original bytes of the message not kept")
+	}
+	fields, body := splitMessage(raw)
+	var results []*Verification
+	for _, f := range fields {
+		if strings.EqualFold(f.name, hDKIMSignature) {
+			results = append(results, verify(f, fields, body, r, false))
+		}
+	}
+	return results, nil
+}
+
+// verify checks a DKIM-Signature, or an ARC-Message-Signature if arc is
+// set, which has no version and an instance for i=.
+func verify(sig field, fields []field, body []byte, r Resolver, arc bool) *Verification {
+	v := &Verification{BodyLength: -1}
+	result, err := v.verify(sig, fields, body, r, arc)
+	v.Result = result
+	if err != nil {
+		v.Err = errors.Wrap(err, "dkim")
+	}
+	return v
+}
+
+func (v *Verification) verify(sig field, fields []field, body []byte, r Resolver, arc bool) (Result, error) {
+	tags, err := parseTags(sig.value())
+	if err != nil {
+		return PermError, err
+	}
+	required := []string{"a", "b", "bh", "d", "h", "s"}
+	if !arc {
+		required = append(required, "v")
+	}
+	for _, name := range required {
+		if tags[name] == "" {
+			return PermError, errors.Errorf("missing tag %s=", name)
+		}
+	}
+	if !arc && tags["v"] != "1" {
+		return PermError, errors.Errorf("unsupported version %q", tags["v"])
+	}
+	v.Domain = strings.ToLower(tags["d"])
+	v.Selector = tags["s"]
+	v.Algorithm = strings.ToLower(tags["a"])
+	for _, k := range strings.Split(tags["h"], ":") {
+		v.HeaderKeys = append(v.HeaderKeys, strings.TrimSpace(k))
+	}
+	v.Identifier = "@" + v.Domain
+	if i, ok := tags["i"]; ok && !arc {
+		v.Identifier = i
+		at := strings.LastIndexByte(i, '@')
+		domain := strings.ToLower(i[at+1:])
+		if at < 0 || domain != v.Domain && !strings.HasSuffix(domain, "."+v.Domain) {
+			return PermError, errors.Errorf("identifier %q not in domain %q", i, v.Domain)
+		}
+	}
+	signsFrom := false
+	for _, k := range v.HeaderKeys {
+		signsFrom = signsFrom || strings.EqualFold(k, "From")
+	}
+	if !signsFrom {
+		return PermError, errors.New("From not signed")
+	}
+	if l, ok := tags["l"]; ok {
+		if v.BodyLength, err = strconv.ParseInt(l, 10, 64); err != nil || v.BodyLength < 0 {
+			return PermError, errors.Errorf("invalid body length %q", l)
+		}
+	}
+	if t, ok := tags["t"]; ok {
+		sec, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return PermError, errors.Errorf("invalid timestamp %q", t)
+		}
+		v.Time = time.Unix(sec, 0)
+	}
+	if x, ok := tags["x"]; ok {
+		sec, err := strconv.ParseInt(x, 10, 64)
+		if err != nil {
+			return PermError, errors.Errorf("invalid expiration %q", x)
+		}
+		v.Expiration = time.Unix(sec, 0)
+		if time.Now().After(v.Expiration) {
+			return PermError, errors.New("signature expired")
+		}
+	}
+	headerCanon, bodyCanon, err := parseCanonicalization(tags["c"])
+	if err != nil {
+		return PermError, err
+	}
+	if q, ok := tags["q"]; ok && !strings.Contains(strings.ToLower(q), "dns/txt") {
+		return PermError, errors.Errorf("unsupported query method %q", q)
+	}
+	if v.Algorithm != RSASHA256 && v.Algorithm != Ed25519SHA256 {
+		return PermError, errors.Errorf("unsupported algorithm %q", v.Algorithm)
+	}
+	signature, err := base64.StdEncoding.DecodeString(stripSpace(tags["b"]))
+	if err != nil {
+		return PermError, errors.Wrap(err, "invalid signature")
+	}
+	bodyHash, err := base64.StdEncoding.DecodeString(stripSpace(tags["bh"]))
+	if err != nil {
+		return PermError, errors.Wrap(err, "invalid body hash")
+	}
+
+	key, result, err := v.lookupKey(r)
+	if err != nil {
+		return result, err
+	}
+
+	canonical := canonicalBody(body, bodyCanon)
+	if v.BodyLength >= 0 {
+		if v.BodyLength > int64(len(canonical)) {
+			return PermError, errors.Errorf("body length %d beyond the body", v.BodyLength)
+		}
+		canonical = canonical[:v.BodyLength]
+	}
+	sum := sha256.Sum256(canonical)
+	if subtle.ConstantTimeCompare(sum[:], bodyHash) != 1 {
+		return Fail, errors.New("body hash mismatch")
+	}
+
+	h := sha256.New()
+	for _, f := range selectHeaders(fields, v.HeaderKeys) {
+		h.Write([]byte(canonicalHeader(f, headerCanon)))
+	}
+	unsigned := field{name: sig.name, raw: removeSignature(sig.raw)}
+	h.Write([]byte(strings.TrimSuffix(canonicalHeader(unsigned, headerCanon), "\r\n")))
+	if err := checkSignature(key, h.Sum(nil), signature); err != nil {
+		return Fail, err
+	}
+	return Pass, nil
+}
+
+// checkSignature verifies the signature of the hashed header fields.
+func checkSignature(key crypto.PublicKey, hashed, signature []byte) error {
+	var err error
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed, signature)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, hashed, signature) {
+			err = errors.New("ed25519 verification failed")
+		}
+	}
+	if err != nil {
+		return errors.Wrap(err, "signature mismatch")
+	}
+	return nil
+}
+
+// lookupKey returns the public key of the signature.
+func (v *Verification) lookupKey(r Resolver) (crypto.PublicKey, Result, error) {
+	txts, err := r.LookupTXT(v.Selector + "._domainkey." + v.Domain)
+	if err == ErrKeyNotFound {
+		return nil, PermError, err
+	}
+	if err != nil {
+		return nil, TempError, errors.Wrap(err, "key lookup")
+	}
+	if len(txts) == 0 {
+		return nil, PermError, ErrKeyNotFound
+	}
+	tags, err := parseTags(txts[0])
+	if err != nil {
+		return nil, PermError, errors.Wrap(err, "key record")
+	}
+	if kv, ok := tags["v"]; ok && kv != "DKIM1" {
+		return nil, PermError, errors.Errorf("unsupported key version %q", kv)
+	}
+	if hs, ok := tags["h"]; ok && !containsTag(hs, "sha256") {
+		return nil, PermError, errors.Errorf("key does not allow sha256: %q", hs)
+	}
+	if s, ok := tags["s"]; ok && !containsTag(s, "email") && !containsTag(s, "*") {
+		return nil, PermError, errors.Errorf("key not for email: %q", s)
+	}
+	if t, ok := tags["t"]; ok && containsTag(t, "s") {
+		// no subdomains in the identifier
+		if !strings.EqualFold(v.Identifier[strings.LastIndexByte(v.Identifier, '@')+1:], v.Domain) {
+			return nil, PermError, errors.Errorf("identifier %q must be in %q", v.Identifier, v.Domain)
+		}
+	}
+	p := stripSpace(tags["p"])
+	if p == "" {
+		return nil, PermError, errors.New("key revoked")
+	}
+	data, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		return nil, PermError, errors.Wrap(err, "invalid key")
+	}
+	keyType := strings.ToLower(tags["k"])
+	if keyType == "" {
+		keyType = "rsa"
+	}
+	if keyType+"-sha256" != v.Algorithm {
+		return nil, PermError, errors.Errorf("key type %q does not match algorithm %q", keyType, v.Algorithm)
+	}
+	switch keyType {
+	case "rsa":
+		var key *rsa.PublicKey
+		if pub, err := x509.ParsePKIXPublicKey(data); err == nil {
+			key, _ = pub.(*rsa.PublicKey)
+		} else if key, err = x509.ParsePKCS1PublicKey(data); err != nil {
+			return nil, PermError, errors.Wrap(err, "invalid key")
+		}
+		if key == nil {
+			return nil, PermError, errors.New("not an RSA key")
+		}
+		if key.N.BitLen() < 1024 {
+			return nil, PermError, errors.Errorf("RSA key of %d bits too short", key.N.BitLen())
+		}
+		return key, Pass, nil
+	case "ed25519":
+		if len(data) != ed25519.PublicKeySize {
+			return nil, PermError, errors.New("invalid ed25519 key")
+		}
+		return ed25519.PublicKey(data), Pass, nil
+	}
+	return nil, PermError, errors.Errorf("unsupported key type %q", keyType)
+}
+
+// containsTag reports whether the colon separated list contains value.
+func containsTag(list, value string) bool {
+	for _, v := range strings.Split(list, ":") {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+	return false
+}
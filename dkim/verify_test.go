@@ -0,0 +1,173 @@
+package dkim
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/daogan/emime"
+)
+
+// RFC 8463 Appendix A
+const rfc8463 = "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+	" d=football.example.com; i=@football.example.com;\r\n" +
+	" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n" +
+	" subject : date : message-id : from : subject : date;\r\n" +
+	" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+	" b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus\r\n" +
+	" Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==\r\n" +
+	"DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed;\r\n" +
+	" d=football.example.com; i=@football.example.com;\r\n" +
+	" q=dns/txt; s=test; t=1528637909; h=from : to : subject :\r\n" +
+	" date : message-id : from : subject : date;\r\n" +
+	" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+	" b=F45dVWDfMbQDGHJFlXUNB2HKfbCeLRyhDXgFpEL8GwpsRe0IeIixNTe3\r\n" +
+	" DhCVlUrSjV4BwcVcOF6+FF3Zo9Rpo1tFOeS9mPYQTnGdaSGsgeefOsk2Jz\r\n" +
+	" dA+L10TeYt9BgDfQNZtKdN1WO//KgIqXP7OdEFE4LjFYNcUxZQ4FADY+8=\r\n" +
+	"From: Joe SixPack <joe@football.example.com>\r\n" +
+	"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+	"Subject: Is dinner ready?\r\n" +
+	"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+	"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+	"\r\n" +
+	"Hi.\r\n" +
+	"\r\n" +
+	"We lost the game.  Are you hungry yet?\r\n" +
+	"\r\n" +
+	"Joe.\r\n"
+
+var rfc8463Keys = MapResolver{
+	"brisbane._domainkey.football.example.com": "v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=",
+	"test._domainkey.football.example.com": "v=DKIM1; k=rsa; p=MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQDkHlOQoBTzWRiGs5V6NpP3id" +
+		"Y6Wk08a5qhdR6wy5bdOKb2jLQiY/J16JYi0Qvx/byYzCNb3W91y3FutACDfzwQ/BC/e/8uBsCR+yz1Lxj+PL6lHvqMKrM3rG4hstT5QjvHO9PzoxZyVYLzBfO2EeC3Ip3G+2kryOTIKT+l/K4w3QIDAQAB",
+}
+
+func TestVerifyRFC8463(t *testing.T) {
+	root, err := emime.ParseWithOptions(strings.NewReader(rfc8463), &emime.ParseOptions{KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := Verify(root, rfc8463Keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got: %d results, want: 2", len(results))
+	}
+	for i, alg := range []string{Ed25519SHA256, RSASHA256} {
+		v := results[i]
+		if v.Result != Pass || v.Algorithm != alg || v.Domain != "football.example.com" || v.BodyLength != -1 {
+			t.Fatalf("got: %+v", v)
+		}
+	}
+
+	tests := []struct {
+		old, new string
+		want     Result
+	}{
+		{"We lost the game.", "We won the game.", Fail},
+		{"Subject: Is dinner ready?", "Subject: Is lunch ready?", Fail},
+		// relaxed canonicalization
+		{"Subject: Is dinner ready?", "Subject:   Is dinner\r\n  ready?  ", Pass},
+		{"Joe.\r\n", "Joe.  \r\n\r\n\r\n", Pass},
+		// unsigned fields
+		{"Message-ID:", "X-Spam: yes\r\nMessage-ID:", Pass},
+	}
+	for _, tt := range tests {
+		root, err := emime.ParseWithOptions(strings.NewReader(strings.Replace(rfc8463, tt.old, tt.new, 1)), &emime.ParseOptions{KeepRaw: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := Verify(root, rfc8463Keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range results {
+			if v.Result != tt.want {
+				t.Fatalf("%q: got: %s %v, want: %s", tt.new, v.Result, v.Err, tt.want)
+			}
+		}
+	}
+
+	root, err = emime.ParseWithOptions(strings.NewReader(rfc8463), &emime.ParseOptions{KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err = Verify(root, MapResolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Result != PermError || results[0].Err == nil {
+		t.Fatalf("got: %+v", results[0])
+	}
+}
+
+func TestCanonicalization(t *testing.T) {
+	// RFC 6376 3.4.6
+	fields, body := splitMessage([]byte("A: X\r\nB : Y\t\r\n\tZ  \r\n\r\n C \r\nD \t E\r\n\r\n\r\n"))
+	var relaxed, simple string
+	for _, f := range fields {
+		relaxed += canonicalHeader(f, Relaxed)
+		simple += canonicalHeader(f, Simple)
+	}
+	if want := "a:X\r\nb:Y Z\r\n"; relaxed != want {
+		t.Fatalf("got: %q, want: %q", relaxed, want)
+	}
+	if want := "A: X\r\nB : Y\t\r\n\tZ  \r\n"; simple != want {
+		t.Fatalf("got: %q, want: %q", simple, want)
+	}
+	if got, want := string(canonicalBody(body, Relaxed)), " C\r\nD E\r\n"; got != want {
+		t.Fatalf("got: %q, want: %q", got, want)
+	}
+	if got, want := string(canonicalBody(body, Simple)), " C \r\nD \t E\r\n"; got != want {
+		t.Fatalf("got: %q, want: %q", got, want)
+	}
+	if got := string(canonicalBody(nil, Simple)); got != "\r\n" {
+		t.Fatalf("got: %q, want: %q", got, "\r\n")
+	}
+	if got := string(canonicalBody([]byte("\r\n\r\n"), Relaxed)); got != "" {
+		t.Fatalf("got: %q, want: %q", got, "")
+	}
+}
+
+func TestVerifyBodyLength(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := MapResolver{"ed._domainkey.example.com": "k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub)}
+
+	// signed by hand, the signer commits to the first 13 bytes of the body
+	from := "From: alice@example.com\r\n"
+	bodyHash := sha256.Sum256([]byte("signed part\r\n"))
+	sig := "DKIM-Signature: v=1; a=ed25519-sha256; c=simple/simple; d=example.com; s=ed;\r\n" +
+		" l=13; h=from; bh=" + base64.StdEncoding.EncodeToString(bodyHash[:]) + "; b="
+	hashed := sha256.Sum256([]byte(from + sig))
+	sig += base64.StdEncoding.EncodeToString(ed25519.Sign(key, hashed[:])) + "\r\n"
+
+	tests := []struct {
+		body string
+		want Result
+	}{
+		{"signed part\r\n", Pass},
+		{"signed part\r\nappended\r\n", Pass},
+		{"signed", PermError},
+		{"signed part!\r\n", Fail},
+	}
+	for _, tt := range tests {
+		root, err := emime.ParseWithOptions(strings.NewReader(sig+from+"\r\n"+tt.body), &emime.ParseOptions{KeepRaw: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := Verify(root, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := results[0]; v.Result != tt.want || v.BodyLength != 13 {
+			t.Fatalf("%q: got: %s %v, want: %s", tt.body, v.Result, v.Err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package emime
+
+import (
+	"bytes"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ctReport         = "multipart/report"
+	ctRFC822Headers  = "text/rfc822-headers"
+	rtDeliveryStatus = "delivery-status"
+	rtGlobalStatus   = "global-delivery-status"
+)
+
+// Confidence tells how reliably a bounce was recognised.
+type Confidence int
+
+const (
+	ConfidenceNone   Confidence = iota // Not a bounce.
+	ConfidenceLow                      // Bounce-like sender or subject, unknown body.
+	ConfidenceMedium                   // Known non-standard body format.
+	ConfidenceHigh                     // A standard multipart/report, RFC 3464.
+)
+
+// ErrNotDSN is returned by Part.DSN when no bounce was recognised.
+var ErrNotDSN = errors.New("not a delivery status notification")
+
+// DSN is a delivery status notification, a bounce.
+type DSN struct {
+	ReportingMTA string    // Reporting MTA, without the "dns;" type.
+	ArrivalDate  time.Time // Zero if missing.
+	Recipients   []*DSNRecipient
+	// Original is the returned message, or only its header of a
+	// `text/rfc822-headers` part, nil if not returned.
+	Original   *Part
+	Confidence Confidence
+	Format     string // "rfc3464", "exchange", "qmail", "yahoo" or "unknown".
+}
+
+// DSNRecipient is the delivery status of one recipient.
+type DSNRecipient struct {
+	FinalRecipient    string // Address, without the "rfc822;" type.
+	OriginalRecipient string
+	Action            string // "failed", "delayed", "delivered", "relayed" or "expanded".
+	Status            string // Enhanced status code, e.g. "5.1.1".
+	DiagnosticCode    string // Remote reply, without the "smtp;" type.
+	RemoteMTA         string
+	// Fields are all fields of the recipient, nil for non-standard bounces.
+	Fields textproto.MIMEHeader
+}
+
+var (
+	dottedNumberRE = regexp.MustCompile(`\d+(\.\d+)*`)
+	replyCodeRE    = regexp.MustCompile(`\b([245])\d\d\b`)
+	bracketAddrRE  = regexp.MustCompile(`^<([^<>\s]+@[^<>\s]+)>:?\s*$`)
+	plainAddrRE    = regexp.MustCompile(`^\s*([^\s<>@"]+@[^\s<>@"]+?)\.?(\s|$)`)
+)
+
+// DSN finds and parses the delivery status notification of message p,
+// either a `multipart/report` with a delivery status, or one of the
+// non-standard bounces of Exchange, qmail and Yahoo.
+func (p *Part) DSN() (*DSN, error) {
+	if report := p.findReport(rtDeliveryStatus, rtGlobalStatus); report != nil {
+		return report.reportDSN()
+	}
+	for _, parse := range []func(*Part, string) *DSN{exchangeDSN, qmailDSN} {
+		if dsn := parse(p, bounceText(p)); dsn != nil {
+			return dsn, nil
+		}
+	}
+	if isBounceLike(p) {
+		dsn := &DSN{Confidence: ConfidenceLow, Format: "unknown"}
+		dsn.Original = findOriginal(p)
+		return dsn, nil
+	}
+	return nil, ErrNotDSN
+}
+
+// findReport returns the first `multipart/report` in p of one of the
+// report types.
+func (p *Part) findReport(reportTypes ...string) *Part {
+	if p.ContentType == ctReport {
+		_, params, _ := parseMediaType(p.Header.Get(hContentType))
+		rt := lowerTrim(params["report-type"])
+		for _, t := range reportTypes {
+			if rt == t {
+				return p
+			}
+		}
+	}
+	for _, child := range p.Parts {
+		// not into returned messages
+		if child.isMessage() {
+			continue
+		}
+		if report := child.findReport(reportTypes...); report != nil {
+			return report
+		}
+	}
+	return nil
+}
+
+func (p *Part) reportDSN() (*DSN, error) {
+	dsn := &DSN{Confidence: ConfidenceHigh, Format: "rfc3464"}
+	var status *Part
+	for _, child := range p.Parts {
+		switch {
+		case child.ContentType == ctDeliveryStatus || child.ContentType == ctGlobalStatus:
+			if status == nil {
+				status = child
+			}
+		case dsn.Original == nil:
+			dsn.Original = originalPart(child)
+		}
+	}
+	if status == nil {
+		return nil, errors.New("dsn: report without delivery status")
+	}
+	ds, err := status.DeliveryStatus()
+	if err != nil {
+		return nil, errors.Wrap(err, "dsn")
+	}
+	dsn.ReportingMTA = fieldValue(ds.Message.Get("Reporting-MTA"))
+	if t, err := ParseDate(ds.Message.Get("Arrival-Date")); err == nil {
+		dsn.ArrivalDate = t
+	}
+	for _, fields := range ds.Recipients {
+		dsn.Recipients = append(dsn.Recipients, &DSNRecipient{
+			FinalRecipient:    fieldValue(fields.Get("Final-Recipient")),
+			OriginalRecipient: fieldValue(fields.Get("Original-Recipient")),
+			Action:            lowerTrim(fields.Get("Action")),
+			Status:            strings.TrimSpace(fields.Get("Status")),
+			DiagnosticCode:    fieldValue(fields.Get("Diagnostic-Code")),
+			RemoteMTA:         fieldValue(fields.Get("Remote-MTA")),
+			Fields:            fields,
+		})
+	}
+	return dsn, nil
+}
+
+// originalPart returns the returned message of a report part, nil if p is
+// not one.
+func originalPart(p *Part) *Part {
+	switch {
+	case p.isMessage() && len(p.Parts) > 0:
+		return p.Parts[0]
+	case p.ContentType == ctRFC822Headers:
+		header, _ := splitMessage(p.Content)
+		original, err := Parse(bytes.NewReader(append(header[:len(header):len(header)], crnl...)))
+		if err != nil {
+			return nil
+		}
+		return original
+	}
+	return nil
+}
+
+// findOriginal returns the first returned message in p.
+func findOriginal(p *Part) *Part {
+	if original := originalPart(p); original != nil {
+		return original
+	}
+	for _, child := range p.Parts {
+		if original := findOriginal(child); original != nil {
+			return original
+		}
+	}
+	return nil
+}
+
+// fieldValue strips the type of a typed DSN field, e.g. "rfc822; a@b".
+func fieldValue(value string) string {
+	if i := strings.IndexByte(value, ';'); i >= 0 {
+		value = value[i+1:]
+	}
+	return strings.TrimSpace(value)
+}
+
+// bounceText returns the first plain text content of p, with LF line
+// endings.
+func bounceText(p *Part) string {
+	if len(p.Parts) == 0 {
+		if p.ContentType == ctTextPlain || p.ContentType == "" {
+			return strings.Replace(string(p.Content), "\r\n", "\n", -1)
+		}
+		return ""
+	}
+	for _, child := range p.Parts {
+		if child.isMessage() {
+			continue
+		}
+		if text := bounceText(child); text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+// isBounceLike reports whether the sender or subject of p look like a
+// bounce.
+func isBounceLike(p *Part) bool {
+	from := strings.ToLower(p.Header.Get(hFrom))
+	for _, sender := range []string{"mailer-daemon", "postmaster", "mail delivery"} {
+		if strings.Contains(from, sender) {
+			return true
+		}
+	}
+	subject := strings.ToLower(decodeHeader(p.Header.Get("Subject")))
+	for _, s := range []string{"undeliver", "delivery status notification", "delivery failure",
+		"failure notice", "returned mail", "mail delivery failed", "delivery has failed"} {
+		if strings.Contains(subject, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// exchangeDSN parses the plain text bounces of Exchange:
+//
+//	Your message did not reach some or all of the intended recipients.
+//	...
+//	The following recipient(s) could not be reached:
+//
+//	      a@example.com on 1/2/2006 3:04 PM
+//	            The e-mail address could not be found.
+//	            <mx.example.com #5.1.1>
+//
+// and of Exchange Online: "Delivery has failed to these recipients or groups:".
+func exchangeDSN(p *Part, text string) *DSN {
+	markers := []string{
+		"the following recipient(s) could not be reached:",
+		"the following recipient(s) cannot be reached:",
+		"delivery has failed to these recipients or groups:",
+	}
+	lower := strings.ToLower(text)
+	start := -1
+	for _, marker := range markers {
+		if i := strings.Index(lower, marker); i >= 0 {
+			start = i + len(marker)
+			break
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+	dsn := &DSN{Confidence: ConfidenceMedium, Format: "exchange"}
+	// an address starts the lines of its recipient, Exchange Online lists
+	// the recipients again with the diagnostics for administrators
+	byAddr := make(map[string]int)
+	var diags [][]string
+	current := -1
+	for _, line := range strings.Split(text[start:], "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(strings.ToLower(trimmed), "original message headers") {
+			break
+		}
+		if m := plainAddrRE.FindStringSubmatch(trimmed); m != nil {
+			addr := strings.ToLower(m[1])
+			i, ok := byAddr[addr]
+			if !ok {
+				i = len(dsn.Recipients)
+				byAddr[addr] = i
+				dsn.Recipients = append(dsn.Recipients, &DSNRecipient{FinalRecipient: m[1]})
+				diags = append(diags, nil)
+			}
+			current = i
+			continue
+		}
+		if current >= 0 && trimmed != "" {
+			diags[current] = append(diags[current], trimmed)
+		}
+	}
+	if len(dsn.Recipients) == 0 {
+		return nil
+	}
+	for i, rcpt := range dsn.Recipients {
+		rcpt.setDiagnostic(strings.Join(diags[i], " "))
+	}
+	dsn.Original = findOriginal(p)
+	return dsn
+}
+
+// qmailDSN parses the bounces of qmail, and of Yahoo which is alike:
+//
+//	Hi. This is the qmail-send program at example.com.
+//	I'm afraid I wasn't able to deliver your message to the following addresses.
+//	This is a permanent error; I've given up. Sorry it didn't work out.
+//
+//	<a@example.net>:
+//	Sorry, no mailbox here by that name. (#5.1.1)
+//
+//	--- Below this line is a copy of the message.
+func qmailDSN(p *Part, text string) *DSN {
+	lower := strings.ToLower(text)
+	var dsn *DSN
+	switch {
+	case strings.Contains(lower, "this is the qmail-send program"):
+		dsn = &DSN{Confidence: ConfidenceMedium, Format: "qmail"}
+		if i := strings.Index(lower, "program at "); i >= 0 {
+			host := strings.Fields(text[i+len("program at "):])
+			if len(host) > 0 {
+				dsn.ReportingMTA = strings.TrimRight(host[0], ".:")
+			}
+		}
+	case strings.Contains(lower, "message from yahoo") ||
+		strings.Contains(lower, "sorry, we were unable to deliver your message"):
+		dsn = &DSN{Confidence: ConfidenceMedium, Format: "yahoo"}
+	default:
+		return nil
+	}
+	delayed := strings.Contains(lower, "i'm still trying") ||
+		strings.Contains(lower, "not been able to deliver your message yet")
+
+	body := text
+	var copied string
+	for _, marker := range []string{
+		"--- Below this line is a copy of the message.",
+		"--- Enclosed are the original headers of the message.",
+		"--- Below this line is the original bounce.",
+	} {
+		if i := strings.Index(body, marker); i >= 0 {
+			copied = strings.TrimLeft(body[i+len(marker):], "\n")
+			body = body[:i]
+			break
+		}
+	}
+
+	var rcpt *DSNRecipient
+	var diag []string
+	flush := func() {
+		if rcpt != nil {
+			rcpt.setDiagnostic(strings.Join(diag, " "))
+			if delayed && rcpt.Action == "failed" {
+				rcpt.Action = "delayed"
+			}
+			dsn.Recipients = append(dsn.Recipients, rcpt)
+		}
+		rcpt, diag = nil, nil
+	}
+	for _, line := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if m := bracketAddrRE.FindStringSubmatch(trimmed); m != nil {
+			flush()
+			rcpt = &DSNRecipient{FinalRecipient: m[1]}
+			continue
+		}
+		if rcpt != nil && trimmed != "" {
+			diag = append(diag, trimmed)
+		}
+	}
+	flush()
+	if len(dsn.Recipients) == 0 {
+		return nil
+	}
+	dsn.Original = findOriginal(p)
+	if dsn.Original == nil && copied != "" {
+		if original, err := Parse(strings.NewReader(copied)); err == nil {
+			dsn.Original = original
+		}
+	}
+	return dsn
+}
+
+// setDiagnostic sets the diagnostic text of a non-standard bounce and the
+// status and action derived from it.
+func (r *DSNRecipient) setDiagnostic(diag string) {
+	r.DiagnosticCode = diag
+	if status := enhancedStatus(diag); status != "" {
+		r.Status = status
+	} else if m := replyCodeRE.FindStringSubmatch(diag); m != nil {
+		r.Status = m[1] + ".0.0"
+	} else {
+		// listed as undeliverable, the reason unknown
+		r.Status = "5.0.0"
+	}
+	switch r.Status[0] {
+	case '2':
+		r.Action = "delivered"
+	case '4':
+		r.Action = "delayed"
+	default:
+		r.Action = "failed"
+	}
+}
+
+// enhancedStatus returns the first enhanced status code in s, IP addresses
+// and versions are skipped.
+func enhancedStatus(s string) string {
+	for _, number := range dottedNumberRE.FindAllString(s, -1) {
+		fields := strings.Split(number, ".")
+		if len(fields) == 3 && len(fields[0]) == 1 && strings.ContainsAny(fields[0], "245") &&
+			len(fields[1]) <= 3 && len(fields[2]) <= 3 {
+			return number
+		}
+	}
+	return ""
+}
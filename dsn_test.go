@@ -0,0 +1,178 @@
+package emime
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDSNReport(t *testing.T) {
+	input := "From: MAILER-DAEMON@mx.example.com\r\n" +
+		"Subject: Undelivered Mail Returned to Sender\r\n" +
+		"Content-Type: multipart/report; report-type=delivery-status; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"I'm sorry to have to inform you that your message could not be delivered.\r\n" +
+		"--b\r\n" +
+		"Content-Type: message/delivery-status\r\n" +
+		"\r\n" +
+		"Reporting-MTA: dns; mx.example.com\r\n" +
+		"Arrival-Date: Mon, 2 Jan 2006 15:04:05 +0000\r\n" +
+		"\r\n" +
+		"Final-Recipient: rfc822; a@example.net\r\n" +
+		"Original-Recipient: rfc822; alias@example.net\r\n" +
+		"Action: failed\r\n" +
+		"Status: 5.1.1\r\n" +
+		"Remote-MTA: dns; mx.example.net\r\n" +
+		"Diagnostic-Code: smtp; 550 5.1.1 user unknown\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/rfc822-headers\r\n" +
+		"\r\n" +
+		"From: sender@example.com\r\n" +
+		"Subject: hello\r\n" +
+		"\r\n" +
+		"--b--\r\n"
+	root, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dsn, err := root.DSN()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dsn.Confidence != ConfidenceHigh || dsn.ReportingMTA != "mx.example.com" || dsn.ArrivalDate.IsZero() {
+		t.Fatalf("got: %+v", dsn)
+	}
+	if len(dsn.Recipients) != 1 {
+		t.Fatalf("got: %d recipients, want: 1", len(dsn.Recipients))
+	}
+	want := DSNRecipient{
+		FinalRecipient:    "a@example.net",
+		OriginalRecipient: "alias@example.net",
+		Action:            "failed",
+		Status:            "5.1.1",
+		DiagnosticCode:    "550 5.1.1 user unknown",
+		RemoteMTA:         "mx.example.net",
+	}
+	got := *dsn.Recipients[0]
+	got.Fields = nil
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got: %+v, want: %+v", got, want)
+	}
+	if dsn.Original == nil || dsn.Original.Header.Get("Subject") != "hello" {
+		t.Fatalf("got: %+v", dsn.Original)
+	}
+}
+
+func TestDSNHeuristics(t *testing.T) {
+	tests := []struct {
+		input  string
+		format string
+		rcpts  []string
+		status []string
+	}{
+		{
+			"From: MAILER-DAEMON@example.com\r\n" +
+				"Subject: failure notice\r\n" +
+				"\r\n" +
+				"Hi. This is the qmail-send program at example.com.\r\n" +
+				"I'm afraid I wasn't able to deliver your message to the following addresses.\r\n" +
+				"This is a permanent error; I've given up. Sorry it didn't work out.\r\n" +
+				"\r\n" +
+				"<a@example.net>:\r\n" +
+				"Sorry, no mailbox here by that name. (#5.1.1)\r\n" +
+				"\r\n" +
+				"<b@example.net>:\r\n" +
+				"192.0.4.1 does not like recipient.\r\n" +
+				"Remote host said: 552 mailbox full\r\n" +
+				"\r\n" +
+				"--- Below this line is a copy of the message.\r\n" +
+				"\r\n" +
+				"From: sender@example.com\r\n" +
+				"Subject: hello\r\n" +
+				"\r\n" +
+				"body\r\n",
+			"qmail", []string{"a@example.net", "b@example.net"}, []string{"5.1.1", "5.0.0"},
+		},
+		{
+			"From: MAILER-DAEMON@yahoo.com\r\n" +
+				"Subject: Failure Notice\r\n" +
+				"\r\n" +
+				"Sorry, we were unable to deliver your message to the following address.\r\n" +
+				"\r\n" +
+				"<a@example.net>:\r\n" +
+				"Remote host said: 550 5.7.1 rejected [RCPT_TO]\r\n" +
+				"\r\n" +
+				"--- Below this line is a copy of the message.\r\n",
+			"yahoo", []string{"a@example.net"}, []string{"5.7.1"},
+		},
+		{
+			"From: System Administrator <postmaster@example.com>\r\n" +
+				"Subject: Undeliverable: hello\r\n" +
+				"\r\n" +
+				"Your message did not reach some or all of the intended recipients.\r\n" +
+				"\r\n" +
+				"      Subject:\thello\r\n" +
+				"      Sent:\t1/2/2006 3:04 PM\r\n" +
+				"\r\n" +
+				"The following recipient(s) could not be reached:\r\n" +
+				"\r\n" +
+				"      a@example.net on 1/2/2006 3:04 PM\r\n" +
+				"            The e-mail address you entered couldn't be found.\r\n" +
+				"            <mx.example.com #5.1.1>\r\n" +
+				"\r\n" +
+				"      b@example.net on 1/2/2006 3:04 PM\r\n" +
+				"            Could not deliver the message in the time limit specified.\r\n" +
+				"            <mx.example.com #4.4.7>\r\n",
+			"exchange", []string{"a@example.net", "b@example.net"}, []string{"5.1.1", "4.4.7"},
+		},
+	}
+	for _, tt := range tests {
+		root, err := Parse(strings.NewReader(tt.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dsn, err := root.DSN()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dsn.Format != tt.format || dsn.Confidence != ConfidenceMedium {
+			t.Fatalf("got: %s %d, want: %s", dsn.Format, dsn.Confidence, tt.format)
+		}
+		if len(dsn.Recipients) != len(tt.rcpts) {
+			t.Fatalf("%s: got: %+v, want: %v", tt.format, dsn.Recipients, tt.rcpts)
+		}
+		for i, rcpt := range dsn.Recipients {
+			if rcpt.FinalRecipient != tt.rcpts[i] || rcpt.Status != tt.status[i] {
+				t.Fatalf("%s: got: %+v, want: %s %s", tt.format, rcpt, tt.rcpts[i], tt.status[i])
+			}
+		}
+		if tt.format == "qmail" && (dsn.Original == nil || dsn.Original.Header.Get("Subject") != "hello") {
+			t.Fatalf("got: %+v", dsn.Original)
+		}
+	}
+
+	root, err := Parse(strings.NewReader("From: a@example.com\r\nSubject: hi\r\n\r\nhello\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := root.DSN(); err != ErrNotDSN {
+		t.Fatalf("got: %v, want: %v", err, ErrNotDSN)
+	}
+}
+
+func TestDSNOriginalHeaders(t *testing.T) {
+	// LF line breaks, the header is followed by more content
+	content := "From: sender@example.com\nSubject: hello\n\nbody\n"
+	p := &Part{ContentType: ctRFC822Headers, Content: []byte(content)}
+	original := originalPart(p)
+	if original == nil || original.Header.Get("Subject") != "hello" {
+		t.Fatalf("got: %+v", original)
+	}
+	if string(p.Content) != content {
+		t.Fatalf("got: %q, want: %q", p.Content, content)
+	}
+}
@@ -6,23 +6,52 @@ import (
 	"encoding/base64"
 	"io"
 	"io/ioutil"
-	"mime"
 	"mime/quotedprintable"
 	"net/textproto"
 	"strings"
 
 	"github.com/daogan/emime/internal/coding"
+	"github.com/pkg/errors"
 )
 
-// Encode encodes the Part tree back to plain text.
+// EncodeOptions controls how parts are encoded.
+type EncodeOptions struct {
+	// Allow8BitMIME allows 8bit content, for transports with 8BITMIME.
+	Allow8BitMIME bool
+	// AllowBinaryMIME allows binary content, for transports with BINARYMIME.
+	AllowBinaryMIME bool
+}
+
+// Encode encodes the Part tree back to plain text. Parts parsed with
+// ParseOptions.KeepRaw which have not been modified since are written as
+// their original bytes.
 func (p *Part) Encode(writer io.Writer) error {
+	return p.EncodeWithOptions(writer, nil)
+}
+
+// EncodeWithOptions encodes the Part tree with the given options.
+// The Content-Transfer-Encoding of a modified leaf is chosen from its
+// content unless it is set to base64 or quoted-printable, the header
+// written matches while p.Header is left as is. A nil opts only allows
+// 7bit output.
+func (p *Part) EncodeWithOptions(writer io.Writer, opts *EncodeOptions) error {
+	if opts == nil {
+		opts = &EncodeOptions{}
+	}
+	if p.unchanged() {
+		return p.encodeRaw(writer, opts)
+	}
 	b := bufio.NewWriter(writer)
-	cte := p.setupPart()
-	p.encodeHeader(b)
+	content := p.charsetContent()
+	cte, header, err := p.setupPart(content, opts)
+	if err != nil {
+		return err
+	}
+	p.encodeHeader(b, header)
 
-	if len(p.Content) > 0 {
+	if len(content) > 0 {
 		b.Write(crnl)
-		if err := p.encodeContent(b, cte); err != nil {
+		if err := encodeContent(b, content, cte); err != nil {
 			return err
 		}
 	}
@@ -31,13 +60,24 @@ func (p *Part) Encode(writer io.Writer) error {
 	}
 
 	// Encode `message/rfc822`.
-	if p.ContentType == ctRFC822 {
+	if p.isMessage() {
+		if p.isEncodedMessage() {
+			msg := &bytes.Buffer{}
+			if err := p.Parts[0].EncodeWithOptions(msg, opts); err != nil {
+				return err
+			}
+			b.Write(crnl)
+			if err := encodeContent(b, msg.Bytes(), p.Header.Get(hContentEncoding)); err != nil {
+				return err
+			}
+			return b.Flush()
+		}
 		if len(p.Parts) > 0 {
 			cte = lowerTrim(p.Header.Get(hContentEncoding))
 			if cte != cteBase64 {
 				b.Write(crnl)
 			}
-			if err := p.Parts[0].Encode(b); err != nil {
+			if err := p.Parts[0].EncodeWithOptions(b, opts); err != nil {
 				return err
 			}
 		}
@@ -51,7 +91,7 @@ func (p *Part) Encode(writer io.Writer) error {
 	for i := 0; i < len(p.Parts); i++ {
 		b.Write(boundary)
 		b.Write(crnl)
-		if err := p.Parts[i].Encode(b); err != nil {
+		if err := p.Parts[i].EncodeWithOptions(b, opts); err != nil {
 			return err
 		}
 	}
@@ -60,13 +100,20 @@ func (p *Part) Encode(writer io.Writer) error {
 	return b.Flush()
 }
 
-func (p *Part) encodeHeader(b *bufio.Writer) {
+// encodeHeader writes the header of p, with a Content-Transfer-Encoding of
+// cte unless it is empty.
+func (p *Part) encodeHeader(b *bufio.Writer, cte string) {
 	// make a copy to avoid modifying original header map
 	tHeader := make(textproto.MIMEHeader)
 	for k, v := range p.Header {
 		tHeader[k] = v
 	}
-	for _, k := range p.HeaderKeys {
+	keys := p.HeaderKeys
+	if cte != "" && len(p.Header[hContentEncoding]) == 0 {
+		keys = append(keys[:len(keys):len(keys)], hContentEncoding)
+		tHeader[hContentEncoding] = []string{cte}
+	}
+	for _, k := range keys {
 		ck := textproto.CanonicalMIMEHeaderKey(k)
 		// duplicate Content-Type header may be deleted
 		if len(tHeader[ck]) < 1 {
@@ -74,13 +121,21 @@ func (p *Part) encodeHeader(b *bufio.Writer) {
 		}
 		val := tHeader[ck][0]
 		tHeader[ck] = tHeader[ck][1:]
+		if ck == hContentEncoding && cte != "" {
+			val = cte
+		}
 		// fix media type if malformed
+		// encode non-ASCII parameters as RFC 2231
 		if ck == hContentType || ck == hContentDisposition {
-			_, _, err := mime.ParseMediaType(val)
+			mtype, params, err := parseMediaType(val)
 			if err != nil {
 				val = fixMediaType(val)
+			} else if hasNonASCII(val) {
+				val = formatMediaType(mtype, params)
 			}
 		}
+		// encode non-ASCII text as RFC 2047 encoded-words
+		val = encodeHeaderValue(k, val)
 		line := k + ":_" + val + "\r\n"
 		wb := wrapLine(76, line)
 		wb[len(k)+1] = ' '
@@ -88,10 +143,10 @@ func (p *Part) encodeHeader(b *bufio.Writer) {
 	}
 }
 
-func (p *Part) encodeContent(b *bufio.Writer, cte string) (err error) {
+// charsetContent returns the content, text is encoded with stated charset.
+func (p *Part) charsetContent() []byte {
 	content := p.Content
-	// encode text with stated charset
-	if strings.HasPrefix(p.ContentType, "text") {
+	if strings.HasPrefix(p.ContentType, "text") && !p.rawCharset && len(content) > 0 {
 		input := bytes.NewReader(p.Content)
 		if r, err := coding.NewCharsetEncoder(p.Charset, input); err == nil {
 			enc, err := ioutil.ReadAll(r)
@@ -100,7 +155,10 @@ func (p *Part) encodeContent(b *bufio.Writer, cte string) (err error) {
 			}
 		}
 	}
+	return content
+}
 
+func encodeContent(b *bufio.Writer, content []byte, cte string) (err error) {
 	switch lowerTrim(cte) {
 	case cteBase64:
 		enc := base64.StdEncoding
@@ -130,29 +188,97 @@ func (p *Part) encodeContent(b *bufio.Writer, cte string) (err error) {
 	return err
 }
 
-func (p *Part) setupPart() (cte string) {
+// setupPart returns the Content-Transfer-Encoding of content and the one
+// to write to the header, empty if the header is kept. The encoding is
+// chosen per call, it is not stored in p.Header.
+func (p *Part) setupPart(content []byte, opts *EncodeOptions) (cte, header string, err error) {
 	if p.Header == nil {
 		p.Header = make(textproto.MIMEHeader)
 	}
+	if !p.isMessage() && len(p.Parts) > 0 && p.Boundary == "" {
+		p.Boundary = genRandomBoundary()
+	}
 	// Restore Content-Transfer-Encoding for base64 rfc822 attachment
-	if len(p.Header) == 0 && p.Parent != nil && p.Parent.ContentType == ctRFC822 {
+	if len(p.Header) == 0 && p.Parent != nil && p.Parent.isMessage() {
 		cte = p.Parent.Header.Get(hContentEncoding)
 	} else {
 		cte = p.Header.Get(hContentEncoding)
 	}
 	cte = lowerTrim(cte)
-	switch cte {
-	case cte7Bit:
-	case cteBase64:
-	case cteQuotedPrintable:
-	case cte8Bit, cteBinary:
-		cte = cte7Bit
-	default:
+	switch {
+	case cte == cteBase64 || cte == cteQuotedPrintable:
+		return cte, "", nil
+	case len(content) == 0:
 		// RFC 2045: 7bit is assumed if CTE header not present.
-		cte = cte7Bit
+		return cte7Bit, "", nil
 	}
-	if p.ContentType != ctRFC822 && len(p.Parts) > 0 && p.Boundary == "" {
-		p.Boundary = genRandomBoundary()
+	enc, err := contentEncoding(p.ContentType, content, opts)
+	if err != nil {
+		return "", "", err
+	}
+	if enc != cte && (cte != "" || enc != cte7Bit) {
+		header = enc
+	}
+	return enc, header, nil
+}
+
+// contentEncoding returns the Content-Transfer-Encoding of content of
+// contentType. Messages other than message/global must not be encoded,
+// rfc2046 5.2.1, they are 7bit, 8bit or binary as opts allows, or an
+// error is returned.
+func contentEncoding(contentType string, content []byte, opts *EncodeOptions) (string, error) {
+	isMessage := strings.HasPrefix(contentType, ctMessagePrefix)
+	if isMessage && contentType != ctGlobal && contentType != ctGlobalHeaders {
+		enc := selectEncoding(content, false, opts)
+		if enc == cteBase64 || enc == cteQuotedPrintable {
+			return "", errors.Errorf("encode: %s content needs 8bit or binary", contentType)
+		}
+		return enc, nil
+	}
+	return selectEncoding(content, isMessage || strings.HasPrefix(contentType, "text/"), opts), nil
+}
+
+// selectEncoding returns the Content-Transfer-Encoding fitting content:
+// 7bit for short ASCII lines, 8bit or binary if allowed by opts,
+// quoted-printable for mostly ASCII text and base64 for anything else.
+func selectEncoding(content []byte, isText bool, opts *EncodeOptions) string {
+	if opts == nil {
+		opts = &EncodeOptions{}
+	}
+	// 8-bit bytes, and controls: NUL, bare CR or LF
+	var nonASCII, controls, lineLen int
+	long := false
+	for i, c := range content {
+		switch {
+		case c == '\n':
+			if i == 0 || content[i-1] != '\r' {
+				controls++
+			}
+			lineLen = 0
+			continue
+		case c == '\r':
+			if i+1 == len(content) || content[i+1] != '\n' {
+				controls++
+			}
+			continue
+		case c >= 0x80:
+			nonASCII++
+		case c == 0 || c < 0x20 && c != '\t' || c == 0x7f:
+			controls++
+		}
+		if lineLen++; lineLen > maxLineLength {
+			long = true
+		}
+	}
+	switch {
+	case nonASCII == 0 && controls == 0 && !long:
+		return cte7Bit
+	case opts.Allow8BitMIME && controls == 0 && !long:
+		return cte8Bit
+	case opts.AllowBinaryMIME:
+		return cteBinary
+	case isText && (nonASCII+controls)*3 <= len(content):
+		return cteQuotedPrintable
 	}
-	return cte
+	return cteBase64
 }
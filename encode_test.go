@@ -0,0 +1,156 @@
+package emime
+
+import (
+	"bytes"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestEncodeSelectEncoding(t *testing.T) {
+	long := strings.Repeat("x", 1200)
+	tests := []struct {
+		cte     string
+		content string
+		opts    *EncodeOptions
+		want    string
+	}{
+		{"8bit", "café\r\n", nil, cteQuotedPrintable},
+		{"8bit", "café\r\n", &EncodeOptions{Allow8BitMIME: true}, cte8Bit},
+		{"", "plain\r\n", nil, ""},
+		{"binary", "plain\r\n", nil, cte7Bit},
+		{"7bit", long, nil, cteQuotedPrintable},
+		{"7bit", long, &EncodeOptions{Allow8BitMIME: true}, cteQuotedPrintable},
+		{"", "nul\x00byte", &EncodeOptions{Allow8BitMIME: true}, cteQuotedPrintable},
+		{"", "nul\x00byte", &EncodeOptions{AllowBinaryMIME: true}, cteBinary},
+		{"", "日本語の本文です", nil, cteBase64},
+		// declared encodings are honoured
+		{"base64", "plain\r\n", nil, cteBase64},
+		{"quoted-printable", "日本語の本文です", nil, cteQuotedPrintable},
+	}
+	for _, tt := range tests {
+		p := &Part{Header: textproto.MIMEHeader{}, ContentType: ctTextPlain, Charset: "utf-8"}
+		p.setHeader(hContentType, "text/plain; charset=utf-8")
+		if tt.cte != "" {
+			p.setHeader(hContentEncoding, tt.cte)
+		}
+		p.Content = []byte(tt.content)
+		buf := &bytes.Buffer{}
+		if err := p.EncodeWithOptions(buf, tt.opts); err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Header.Get(hContentEncoding); got != tt.cte {
+			t.Fatalf("%q: got: %q, want: the header unchanged", tt.content, got)
+		}
+		root, err := Parse(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := root.Header.Get(hContentEncoding); got != tt.want {
+			t.Fatalf("%q: got: %q, want: %q", tt.content, got, tt.want)
+		}
+		if string(root.Content) != tt.content {
+			t.Fatalf("got: %q, want: %q", root.Content, tt.content)
+		}
+	}
+}
+
+func TestEncodeEncodingPerCall(t *testing.T) {
+	p := &Part{Header: textproto.MIMEHeader{}, ContentType: ctTextPlain, Charset: "utf-8"}
+	p.setHeader(hContentType, "text/plain; charset=utf-8")
+	p.Content = []byte("café\r\n")
+	for _, tt := range []struct {
+		opts *EncodeOptions
+		want string
+	}{
+		{nil, cteQuotedPrintable},
+		{&EncodeOptions{Allow8BitMIME: true}, cte8Bit},
+		{nil, cteQuotedPrintable},
+	} {
+		buf := &bytes.Buffer{}
+		if err := p.EncodeWithOptions(buf, tt.opts); err != nil {
+			t.Fatal(err)
+		}
+		root, err := Parse(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := root.Header.Get(hContentEncoding); got != tt.want {
+			t.Fatalf("got: %q, want: %q", got, tt.want)
+		}
+	}
+	if len(p.Header[hContentEncoding]) != 0 || len(p.HeaderKeys) != 1 {
+		t.Fatalf("got: %v, want: the header unchanged", p.HeaderKeys)
+	}
+}
+
+func TestSelectEncoding(t *testing.T) {
+	tests := []struct {
+		content string
+		isText  bool
+		want    string
+	}{
+		{"plain ascii\r\n", true, cte7Bit},
+		{"café au lait\r\n", true, cteQuotedPrintable},
+		{"日本語のテキスト", true, cteBase64},
+		{strings.Repeat("a", 1000), true, cteQuotedPrintable},
+		{"bare\nlf", true, cteQuotedPrintable},
+		{"ascii", false, cte7Bit},
+		{"\x00\x01binary", false, cteBase64},
+	}
+	for _, tt := range tests {
+		if got := selectEncoding([]byte(tt.content), tt.isText, nil); got != tt.want {
+			t.Fatalf("%q: got: %s, want: %s", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestContentEncoding(t *testing.T) {
+	all := &EncodeOptions{Allow8BitMIME: true, AllowBinaryMIME: true}
+	tests := []struct {
+		contentType string
+		content     string
+		opts        *EncodeOptions
+		want        string
+	}{
+		{ctRFC822, "Subject: plain\r\n\r\nascii\r\n", nil, cte7Bit},
+		{ctRFC822, "Subject: café\r\n\r\nau lait\r\n", nil, ""},
+		{ctRFC822, "Subject: café\r\n\r\nau lait\r\n", &EncodeOptions{Allow8BitMIME: true}, cte8Bit},
+		{ctRFC822, "Subject: x\r\n\r\n" + strings.Repeat("a", 1000), &EncodeOptions{Allow8BitMIME: true}, ""},
+		{ctRFC822, "Subject: x\r\n\r\n" + strings.Repeat("a", 1000), all, cteBinary},
+		{ctGlobal, "Subject: café\r\n\r\nau lait\r\n", nil, cteQuotedPrintable},
+		{ctTextPlain, "café au lait\r\n", nil, cteQuotedPrintable},
+	}
+	for _, tt := range tests {
+		got, err := contentEncoding(tt.contentType, []byte(tt.content), tt.opts)
+		if (err != nil) != (tt.want == "") || got != tt.want {
+			t.Fatalf("%s %q: got: %s, %v, want: %s", tt.contentType, tt.content, got, err, tt.want)
+		}
+	}
+
+	root, err := NewBuilder().
+		From(&Address{Address: "alice@example.com"}).
+		Text("see attached").
+		Attach("fwd.eml", ctRFC822, []byte("Subject: café\r\n\r\nau lait\r\n")).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := root.Parts[1].Header.Get(hContentEncoding); got != "" {
+		t.Fatalf("got: %s, want: chosen on encode", got)
+	}
+	if err := root.Encode(&bytes.Buffer{}); err == nil {
+		t.Fatal("want error for an 8-bit message without 8BITMIME")
+	}
+	buf := &bytes.Buffer{}
+	if err := root.EncodeWithOptions(buf, &EncodeOptions{Allow8BitMIME: true}); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := parsed.Parts[1].Header.Get(hContentEncoding); got != cte8Bit {
+		t.Fatalf("got: %s, want: %s", got, cte8Bit)
+	}
+}
@@ -0,0 +1,289 @@
+package emime
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/textproto"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// rfc2047: An 'encoded-word' may not be more than 75 characters long
+	maxEncodedWordLength = 75
+	// length of `=?utf-8?q?` and `?=`
+	encodedWordOverhead = 12
+)
+
+// address list headers, only the phrases of which are encoded
+var addressHeaders = map[string]bool{
+	hFrom:                         true,
+	hSender:                       true,
+	hReplyTo:                      true,
+	hTo:                           true,
+	hCc:                           true,
+	hBcc:                          true,
+	"Resent-From":                 true,
+	"Resent-Sender":               true,
+	"Resent-To":                   true,
+	"Resent-Cc":                   true,
+	"Resent-Bcc":                  true,
+	"Disposition-Notification-To": true,
+}
+
+// encodeHeaderValue encodes the non-ASCII text of a header value as RFC 2047
+// encoded-words. Content-Type and Content-Disposition are left to RFC 2231,
+// 8-bit values other than UTF-8 are kept as is.
+func encodeHeaderValue(key, value string) string {
+	if !hasNonASCII(value) || !utf8.ValidString(value) {
+		return value
+	}
+	ck := textproto.CanonicalMIMEHeaderKey(key)
+	if ck == hContentType || ck == hContentDisposition {
+		return value
+	}
+	// the first encoded-word shares the line with the header name
+	first := maxEncodedWordLength - len(key) - 2
+	if addressHeaders[ck] {
+		return encodeAddressList(value, first)
+	}
+	return encodeText(value, first)
+}
+
+// encodeText encodes unstructured text, leading and trailing ASCII words
+// are kept as is.
+func encodeText(s string, first int) string {
+	words := strings.SplitAfter(s, " ")
+	start, end := -1, -1
+	for i, w := range words {
+		if hasNonASCII(w) {
+			if start < 0 {
+				start = i
+			}
+			end = i
+		}
+	}
+	if start < 0 {
+		return s
+	}
+	prefix := strings.Join(words[:start], "")
+	text := strings.Join(words[start:end+1], "")
+	suffix := strings.Join(words[end+1:], "")
+	// keep the space between the encoded text and the suffix
+	if strings.HasSuffix(text, " ") && suffix != "" {
+		text = text[:len(text)-1]
+		suffix = " " + suffix
+	}
+	if prefix != "" {
+		first = maxEncodedWordLength
+	}
+	return prefix + strings.Join(encodeWords(text, first), " ") + suffix
+}
+
+// encodeWords encodes s as UTF-8 encoded-words, with Q or B encoding,
+// whichever is shorter. Words are split on character boundaries, the
+// first word is at most first characters long.
+func encodeWords(s string, first int) []string {
+	if first < encodedWordOverhead+16 {
+		first = encodedWordOverhead + 16
+	}
+	q := splitWords(s, first, 'q')
+	b := splitWords(s, first, 'b')
+	if wordsLen(q) <= wordsLen(b) {
+		return q
+	}
+	return b
+}
+
+func wordsLen(words []string) int {
+	n := 0
+	for _, w := range words {
+		n += len(w) + 1
+	}
+	return n
+}
+
+func splitWords(s string, first int, enc byte) []string {
+	var words []string
+	max := first - encodedWordOverhead
+	start := 0
+	for start < len(s) {
+		end := start
+		for end < len(s) {
+			_, size := utf8.DecodeRuneInString(s[end:])
+			if encodedLen(s[start:end+size], enc) > max && end > start {
+				break
+			}
+			end += size
+		}
+		words = append(words, fmt.Sprintf("=?utf-8?%c?%s?=", enc, encodeWord(s[start:end], enc)))
+		start = end
+		max = maxEncodedWordLength - encodedWordOverhead
+	}
+	return words
+}
+
+func encodedLen(s string, enc byte) int {
+	if enc == 'b' {
+		return base64.StdEncoding.EncodedLen(len(s))
+	}
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if isQSafe(s[i]) || s[i] == ' ' {
+			n++
+		} else {
+			n += 3
+		}
+	}
+	return n
+}
+
+func encodeWord(s string, enc byte) string {
+	if enc == 'b' {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == ' ':
+			sb.WriteByte('_')
+		case isQSafe(c):
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "=%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// isQSafe reports whether c may appear unencoded in a Q encoded-word,
+// rfc2047 5(3) allows the least characters, in a phrase.
+func isQSafe(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '!' || c == '*' || c == '+' || c == '-' || c == '/'
+}
+
+// encodeAddressList encodes the display names, group names and comments
+// of an address list, addr-specs and delimiters are kept as is.
+func encodeAddressList(s string, first int) string {
+	var sb strings.Builder
+	for _, seg := range splitAddressList(s) {
+		text := seg.text
+		switch {
+		case seg.delim == ':':
+			// group display name
+			text = encodePhrase(text, first)
+		case seg.angle >= 0:
+			text = encodePhrase(text[:seg.angle], first) + encodeComments(text[seg.angle:])
+		default:
+			text = encodeComments(text)
+		}
+		sb.WriteString(text)
+		if seg.delim != 0 {
+			sb.WriteByte(seg.delim)
+		}
+		first = maxEncodedWordLength
+	}
+	return sb.String()
+}
+
+// encodeComments encodes the text of comments with non-ASCII characters,
+// rfc2047 5(2).
+func encodeComments(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '"':
+			j := skipQuoted(s, i)
+			sb.WriteString(s[i:j])
+			i = j
+		case '(':
+			j := skipComment(s, i)
+			text := strings.TrimSuffix(s[i+1:j], ")")
+			if hasNonASCII(text) {
+				text = strings.Join(encodeWords(unquote(strings.TrimSpace(text)), maxEncodedWordLength), " ")
+			}
+			sb.WriteString("(" + text + ")")
+			i = j
+		default:
+			sb.WriteByte(s[i])
+			i++
+		}
+	}
+	return sb.String()
+}
+
+// encodePhrase encodes a display name, keeping the surrounding spaces.
+func encodePhrase(s string, first int) string {
+	phrase := strings.TrimSpace(s)
+	if !hasNonASCII(phrase) {
+		return s
+	}
+	lead := s[:strings.Index(s, phrase)]
+	trail := s[len(lead)+len(phrase):]
+	if trail == "" {
+		trail = " "
+	}
+	if len(phrase) >= 2 && phrase[0] == '"' && phrase[len(phrase)-1] == '"' {
+		phrase = unquote(phrase[1 : len(phrase)-1])
+	}
+	return lead + strings.Join(encodeWords(phrase, first-len(lead)), " ") + trail
+}
+
+func unquote(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// addressSegment is the text of an address list up to a top level
+// delimiter ',', ':' or ';'.
+type addressSegment struct {
+	text  string
+	delim byte // 0 at the end of the list
+	angle int  // index of the top level '<' in text, or -1
+}
+
+// splitAddressList splits an address list at the top level delimiters,
+// delimiters inside quoted-strings, comments and angle brackets are skipped.
+func splitAddressList(s string) []addressSegment {
+	var segs []addressSegment
+	start, angle := 0, -1
+	quoted, escaped := false, false
+	comment, inAngle := 0, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && (quoted || comment > 0):
+			escaped = true
+		case quoted:
+			quoted = c != '"'
+		case c == '"' && comment == 0:
+			quoted = true
+		case c == '(':
+			comment++
+		case c == ')' && comment > 0:
+			comment--
+		case comment > 0:
+		case c == '<':
+			if !inAngle && angle < 0 {
+				angle = i - start
+			}
+			inAngle = true
+		case c == '>':
+			inAngle = false
+		case inAngle:
+		case c == ',' || c == ':' || c == ';':
+			segs = append(segs, addressSegment{text: s[start:i], delim: c, angle: angle})
+			start, angle = i+1, -1
+		}
+	}
+	return append(segs, addressSegment{text: s[start:], angle: angle})
+}
@@ -0,0 +1,79 @@
+package emime
+
+import (
+	"bytes"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestEncodeHeaderWords(t *testing.T) {
+	subjects := []string{
+		"Re: café meeting",
+		"日本語の件名",
+		strings.Repeat("非常に長い件名です。", 12),
+		strings.Repeat("Grüße aus München, ", 8) + "bis bald",
+		"emoji 😀😀😀😀😀😀😀😀😀😀😀😀😀😀😀😀😀😀😀😀😀😀 end",
+	}
+	for _, subject := range subjects {
+		p := &Part{Header: textproto.MIMEHeader{}}
+		p.Header.Set("Subject", subject)
+		p.HeaderKeys = []string{"Subject"}
+		buf := &bytes.Buffer{}
+		if err := p.Encode(buf); err != nil {
+			t.Fatal(err)
+		}
+		encoded := buf.String()
+		for _, line := range strings.Split(strings.TrimSuffix(encoded, "\r\n"), "\r\n") {
+			if hasNonASCII(line) || len(line) > 76 {
+				t.Fatalf("invalid line %q", line)
+			}
+		}
+		root, err := Parse(strings.NewReader(encoded + "\r\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := decodeHeader(root.Header.Get("Subject")); got != subject {
+			t.Fatalf("got: %q, want: %q", got, subject)
+		}
+	}
+}
+
+func TestEncodeAddressList(t *testing.T) {
+	tests := []struct {
+		input string
+		names []string
+	}{
+		{`José Pérez <jose@example.com>`, []string{"José Pérez"}},
+		{`"Müller, Hans" <hans@example.com>, bob@example.com`, []string{"Müller, Hans", ""}},
+		{`Équipe: 李雷 <li@example.com>, han@example.com;, x@example.com`, []string{"李雷", "", ""}},
+		{`plain <a@example.com> (Zoë)`, []string{"plain"}},
+		{`a@example.com (Zoë (née Weiß)), b@example.com (Bob)`, []string{"Zoë (née Weiß)", "Bob"}},
+	}
+	for _, tt := range tests {
+		value := encodeHeaderValue("To", tt.input)
+		if hasNonASCII(value) {
+			t.Fatalf("got: %q", value)
+		}
+		if i := strings.IndexByte(tt.input, '('); i >= 0 {
+			comment := tt.input[i:strings.IndexByte(tt.input, ')')]
+			if !strings.Contains(decodeHeader(value), comment) {
+				t.Fatalf("got: %q, want the comment %q", decodeHeader(value), comment)
+			}
+		}
+		dec := &mail.AddressParser{WordDecoder: nil}
+		addrs, err := dec.ParseList(value)
+		if err != nil {
+			t.Fatalf("%q: %v", value, err)
+		}
+		if len(addrs) != len(tt.names) {
+			t.Fatalf("got: %d addresses, want: %d", len(addrs), len(tt.names))
+		}
+		for i, addr := range addrs {
+			if addr.Name != tt.names[i] {
+				t.Fatalf("got: %q, want: %q", addr.Name, tt.names[i])
+			}
+		}
+	}
+}
@@ -15,6 +15,12 @@ const (
 	hContentEncoding    = "Content-Transfer-Encoding"
 	hContentID          = "Content-ID"
 	hContentType        = "Content-Type"
+	hFrom               = "From"
+	hSender             = "Sender"
+	hReplyTo            = "Reply-To"
+	hTo                 = "To"
+	hCc                 = "Cc"
+	hBcc                = "Bcc"
 
 	// Content-Type
 	ctMultipartPrefix = "multipart/"
@@ -22,6 +28,12 @@ const (
 	ctTextPlain       = "text/plain"
 	ctTextHTML        = "text/html"
 	ctRFC822          = "message/rfc822"
+	ctGlobal          = "message/global"
+	ctGlobalHeaders   = "message/global-headers"
+	ctPartial         = "message/partial"
+	ctExternalBody    = "message/external-body"
+	ctDeliveryStatus  = "message/delivery-status"
+	ctGlobalStatus    = "message/global-delivery-status"
 	ctAppOctetStream  = "application/octet-stream"
 
 	// Content-Transfter-Encoding
@@ -47,22 +59,86 @@ const (
 
 var crnl = []byte{'\r', '\n'}
 
-func readHeader(r *bufio.Reader, p *Part) (textproto.MIMEHeader, error) {
+// headerReader reads header lines, enforcing the header limits.
+type headerReader struct {
+	r     *posReader
+	p     *Part
+	opts  *ParseOptions
+	bytes int
+	lines int
+	eof   bool
+}
+
+// readLine returns the next line without line ending, a partial last line
+// is returned before io.EOF.
+func (h *headerReader) readLine() ([]byte, error) {
+	if h.eof {
+		return nil, io.EOF
+	}
+	var buf []byte
+	for {
+		line, err := h.r.ReadSlice('\n')
+		h.bytes += len(line)
+		if max := h.opts.MaxHeaderBytes; max > 0 && h.bytes > max {
+			return nil, &LimitError{Limit: "MaxHeaderBytes", Max: int64(max), PartID: h.p.PartID}
+		}
+		if err == bufio.ErrBufferFull {
+			buf = append(buf, line...)
+			continue
+		}
+		if buf != nil {
+			line = append(buf, line...)
+		}
+		if err == io.EOF {
+			if len(line) == 0 {
+				return nil, io.EOF
+			}
+			h.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+		h.lines++
+		if max := h.opts.MaxHeaderLines; max > 0 && h.lines > max {
+			return nil, &LimitError{Limit: "MaxHeaderLines", Max: int64(max), PartID: h.p.PartID}
+		}
+		line = bytes.TrimSuffix(line, []byte{'\n'})
+		return bytes.TrimSuffix(line, []byte{'\r'}), nil
+	}
+}
+
+func readHeader(r *posReader, p *Part, opts *ParseOptions) (textproto.MIMEHeader, error) {
 	buf := &bytes.Buffer{}
-	tp := textproto.NewReader(r)
+	hr := &headerReader{r: r, p: p, opts: opts}
 	firstHeader := true
 	for {
-		line, err := tp.ReadLineBytes()
+		offset := r.offset()
+		line, err := hr.readLine()
 		if err != nil {
 			if err == io.EOF {
 				buf.Write(crnl)
 				break
 			}
+			if isLimitError(err) || isDefectError(err) {
+				return nil, err
+			}
 			return nil, errors.WithStack(err)
 		}
+		if opts.Strict && !opts.SMTPUTF8 {
+			if i := bytes.IndexFunc(line, isNonASCII); i >= 0 {
+				return nil, p.defect(opts, DefectNonASCIIHeader, offset+int64(i),
+					"non-ASCII header line: %q", line)
+			}
+		}
 		spaceIdx := bytes.IndexAny(line, " \t\r\n")
 		// start with space, continuation
 		if spaceIdx == 0 {
+			if firstHeader {
+				err := p.defect(opts, DefectFirstLineContinuation, offset,
+					"first header line is a continuation: %q", line)
+				if err != nil {
+					return nil, err
+				}
+			}
 			buf.WriteByte(' ')
 			buf.Write(textproto.TrimBytes(line))
 			continue
@@ -70,6 +146,10 @@ func readHeader(r *bufio.Reader, p *Part) (textproto.MIMEHeader, error) {
 		colonIdx := bytes.IndexByte(line, ':')
 		if colonIdx == 0 {
 			// illegal line, skip
+			err := p.defect(opts, DefectHeaderMissingName, offset, "header without name: %q", line)
+			if err != nil {
+				return nil, err
+			}
 			continue
 		}
 		// contains colon, new header entry
@@ -82,9 +162,14 @@ func readHeader(r *bufio.Reader, p *Part) (textproto.MIMEHeader, error) {
 			// Keep header keys in order
 			headerKey := string(textproto.TrimBytes(line[:colonIdx]))
 			p.HeaderKeys = append(p.HeaderKeys, headerKey)
+			p.headerOffsets = append(p.headerOffsets, offset)
 		} else {
 			if len(line) > 0 {
 				// illegal line, treat as unintented continuation
+				err := p.defect(opts, DefectInvalidHeaderLine, offset, "header line without colon: %q", line)
+				if err != nil {
+					return nil, err
+				}
 				buf.WriteByte(' ')
 				buf.Write(textproto.TrimBytes(line))
 				continue
@@ -96,7 +181,11 @@ func readHeader(r *bufio.Reader, p *Part) (textproto.MIMEHeader, error) {
 		}
 	}
 	buf.Write(crnl) // end of header marker
-	tp = textproto.NewReader(bufio.NewReader(buf))
+	tp := textproto.NewReader(bufio.NewReader(buf))
 	hdr, err := tp.ReadMIMEHeader()
 	return hdr, err
 }
+
+func isNonASCII(r rune) bool {
+	return r >= 0x80
+}
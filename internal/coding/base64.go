@@ -15,8 +15,9 @@ var base64Table = []int8{
 }
 
 type Base64Cleaner struct {
-	r      io.Reader
-	buffer [1024]byte
+	r       io.Reader
+	buffer  [1024]byte
+	invalid int64
 }
 
 func NewBase64Cleaner(r io.Reader) *Base64Cleaner {
@@ -34,10 +35,14 @@ func (bc *Base64Cleaner) Read(p []byte) (n int, err error) {
 	for i := 0; i < bn; i++ {
 		// Strip invalid character range 0x7f ~ 0xff
 		if buf[i] > 127 {
+			bc.invalid++
 			continue
 		}
-		if base64Table[buf[i]&0x7f] < 0 {
+		if v := base64Table[buf[i]&0x7f]; v < 0 {
 			// Strip invalid characters
+			if v == -1 {
+				bc.invalid++
+			}
 			continue
 		}
 		p[n] = buf[i]
@@ -45,3 +50,9 @@ func (bc *Base64Cleaner) Read(p []byte) (n int, err error) {
 	}
 	return
 }
+
+// Invalid returns the number of invalid characters stripped so far,
+// white spaces and padding are not counted.
+func (bc *Base64Cleaner) Invalid() int64 {
+	return bc.invalid
+}
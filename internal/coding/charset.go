@@ -276,3 +276,9 @@ func NewCharsetEncoder(charset string, input io.Reader) (io.Reader, error) {
 	}
 	return transform.NewReader(input, csentry.e.NewEncoder()), nil
 }
+
+// IsUTF8 reports whether charset is a label of UTF-8.
+func IsUTF8(charset string) bool {
+	csentry, ok := encodings[strings.ToLower(strings.TrimSpace(charset))]
+	return ok && csentry.name == utf8
+}
@@ -0,0 +1,256 @@
+// Package maildir reads and delivers messages of Maildir, Maildir++ and
+// MH mail stores, parsed by emime.
+//
+//	d := maildir.Dir("/home/alice/Maildir")
+//	err := d.Walk(func(m *maildir.Message, p *emime.Part, err error) error {
+//		if err != nil || m.HasFlag(maildir.FlagSeen) {
+//			return nil
+//		}
+//		...
+//	})
+//
+// Messages are delivered to tmp/ and linked into new/, so readers never
+// see partial files.
+package maildir
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/daogan/emime"
+	"github.com/pkg/errors"
+)
+
+// Flag is a Maildir message flag.
+type Flag byte
+
+// Flags of the ":2," info suffix, in the required ASCII order.
+const (
+	FlagDraft   Flag = 'D'
+	FlagFlagged Flag = 'F'
+	FlagPassed  Flag = 'P' // Forwarded, bounced or resent.
+	FlagReplied Flag = 'R'
+	FlagSeen    Flag = 'S'
+	FlagTrashed Flag = 'T'
+)
+
+const (
+	dirCur = "cur"
+	dirNew = "new"
+	dirTmp = "tmp"
+
+	infoSeparator = ":2,"
+	// maildirfolder marks a Maildir++ subfolder.
+	maildirfolder = "maildirfolder"
+)
+
+// Message is a message file of a Maildir or MH folder.
+type Message struct {
+	Key   string // Unique name without the info suffix, the number in MH.
+	Path  string
+	New   bool   // In new/, not seen by a mail reader yet.
+	Flags string // Flag letters, sorted.
+}
+
+// HasFlag reports whether the message has flag f.
+func (m *Message) HasFlag(f Flag) bool {
+	return strings.IndexByte(m.Flags, byte(f)) >= 0
+}
+
+// Open opens the message file.
+func (m *Message) Open() (*os.File, error) {
+	return os.Open(m.Path)
+}
+
+// Part parses the message file with emime.Parse.
+func (m *Message) Part() (*emime.Part, error) {
+	f, err := m.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return emime.Parse(f)
+}
+
+// WalkFunc is called for each message, err is the error of parsing it. A
+// non-nil result stops the walk and is returned.
+type WalkFunc func(m *Message, p *emime.Part, err error) error
+
+func walk(messages []*Message, fn WalkFunc) error {
+	for _, m := range messages {
+		p, err := m.Part()
+		if err := fn(m, p, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Dir is the path of a Maildir.
+type Dir string
+
+// Init creates the cur, new and tmp directories of d.
+func (d Dir) Init() error {
+	for _, sub := range []string{dirCur, dirNew, dirTmp} {
+		if err := os.MkdirAll(filepath.Join(string(d), sub), 0700); err != nil {
+			return errors.Wrap(err, "maildir")
+		}
+	}
+	return nil
+}
+
+// Messages returns the messages of new/ and cur/, sorted by key.
+func (d Dir) Messages() ([]*Message, error) {
+	var messages []*Message
+	for _, sub := range []string{dirNew, dirCur} {
+		infos, err := ioutil.ReadDir(filepath.Join(string(d), sub))
+		if err != nil {
+			return nil, errors.Wrap(err, "maildir")
+		}
+		for _, info := range infos {
+			name := info.Name()
+			if info.IsDir() || strings.HasPrefix(name, ".") {
+				continue
+			}
+			m := &Message{Key: name, Path: filepath.Join(string(d), sub, name), New: sub == dirNew}
+			if i := strings.Index(name, infoSeparator); i >= 0 {
+				m.Key, m.Flags = name[:i], sortFlags(name[i+len(infoSeparator):])
+			}
+			messages = append(messages, m)
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].Key < messages[j].Key })
+	return messages, nil
+}
+
+// Walk parses the messages of d in key order and calls fn for each.
+func (d Dir) Walk(fn WalkFunc) error {
+	messages, err := d.Messages()
+	if err != nil {
+		return err
+	}
+	return walk(messages, fn)
+}
+
+// SetFlags moves m to cur/ with flags, which replace its flags.
+func (d Dir) SetFlags(m *Message, flags string) error {
+	flags = sortFlags(flags)
+	path := filepath.Join(string(d), dirCur, m.Key+infoSeparator+flags)
+	if err := os.Rename(m.Path, path); err != nil {
+		return errors.Wrap(err, "maildir")
+	}
+	m.Path, m.New, m.Flags = path, false, flags
+	return nil
+}
+
+// sortFlags returns the flag letters of s sorted, without duplicates.
+func sortFlags(s string) string {
+	b := []byte(s)
+	sort.Slice(b, func(i, j int) bool { return b[i] < b[j] })
+	out := b[:0]
+	for i, c := range b {
+		if i == 0 || c != b[i-1] {
+			out = append(out, c)
+		}
+	}
+	return string(out)
+}
+
+// Deliver encodes p into new/.
+func (d Dir) Deliver(p *emime.Part) (*Message, error) {
+	buf := &bytes.Buffer{}
+	if err := p.Encode(buf); err != nil {
+		return nil, err
+	}
+	return d.DeliverMessage(buf)
+}
+
+// DeliverMessage writes the message r to tmp/ and links it into new/, it
+// is removed from tmp/ afterwards.
+func (d Dir) DeliverMessage(r io.Reader) (*Message, error) {
+	tmp, err := ioutil.TempFile(filepath.Join(string(d), dirTmp), "deliver")
+	if err != nil {
+		return nil, errors.Wrap(err, "maildir")
+	}
+	defer os.Remove(tmp.Name())
+	size, err := io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "maildir")
+	}
+	key := uniqueName(time.Now(), size)
+	path := filepath.Join(string(d), dirNew, key)
+	if err := os.Link(tmp.Name(), path); err != nil {
+		return nil, errors.Wrap(err, "maildir")
+	}
+	return &Message{Key: key, Path: path, New: true}, nil
+}
+
+var deliveries uint32
+
+// uniqueName returns a unique file name, `sec.MusecPpidQn.host,S=size`
+// as Dovecot makes them.
+func uniqueName(t time.Time, size int64) string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	host = strings.NewReplacer("/", `\057`, ":", `\072`).Replace(host)
+	return strconv.FormatInt(t.Unix(), 10) +
+		".M" + strconv.Itoa(t.Nanosecond()/1000) +
+		"P" + strconv.Itoa(os.Getpid()) +
+		"Q" + strconv.FormatUint(uint64(atomic.AddUint32(&deliveries, 1)), 10) +
+		"." + host + ",S=" + strconv.FormatInt(size, 10)
+}
+
+// Folders returns the names of the Maildir++ subfolders of d, e.g.
+// "Sent" or "Archive.2006", sorted.
+func (d Dir) Folders() ([]string, error) {
+	infos, err := ioutil.ReadDir(string(d))
+	if err != nil {
+		return nil, errors.Wrap(err, "maildir")
+	}
+	var names []string
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() && len(name) > 1 && name[0] == '.' && name != ".." {
+			names = append(names, name[1:])
+		}
+	}
+	return names, nil
+}
+
+// Folder returns the Maildir++ subfolder name of d, nested folders are
+// separated by dots.
+func (d Dir) Folder(name string) Dir {
+	return Dir(filepath.Join(string(d), "."+name))
+}
+
+// CreateFolder creates the Maildir++ subfolder name of d.
+func (d Dir) CreateFolder(name string) (Dir, error) {
+	if name == "" || strings.ContainsAny(name, "/\\") || strings.HasPrefix(name, ".") {
+		return "", errors.Errorf("maildir: invalid folder name %q", name)
+	}
+	folder := d.Folder(name)
+	if err := folder.Init(); err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(filepath.Join(string(folder), maildirfolder), os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", errors.Wrap(err, "maildir")
+	}
+	return folder, f.Close()
+}
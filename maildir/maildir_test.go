@@ -0,0 +1,194 @@
+package maildir
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/daogan/emime"
+)
+
+func testMessage(t *testing.T, subject string) *emime.Part {
+	p, err := emime.NewBuilder().
+		From(&emime.Address{Address: "alice@example.com"}).
+		To(&emime.Address{Address: "bob@example.com"}).
+		Subject(subject).
+		Text("Hello\n").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// subjects returns the sorted subjects and flags of the messages walked.
+func subjects(t *testing.T, walk func(WalkFunc) error) string {
+	var got []string
+	err := walk(func(m *Message, p *emime.Part, err error) error {
+		if err != nil {
+			return err
+		}
+		got = append(got, p.Header.Get("Subject")+":"+m.Flags)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	return strings.Join(got, " ")
+}
+
+func TestMaildir(t *testing.T) {
+	root, err := ioutil.TempDir("", "maildir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	d := Dir(root)
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := d.Deliver(testMessage(t, "first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := d.Deliver(testMessage(t, "second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Key == second.Key || !first.New || !strings.Contains(first.Key, ",S=") ||
+		filepath.Dir(first.Path) != filepath.Join(root, "new") {
+		t.Fatalf("got: %+v, %+v", first, second)
+	}
+	if tmp, _ := ioutil.ReadDir(filepath.Join(root, "tmp")); len(tmp) != 0 {
+		t.Fatalf("got: %d files in tmp/, want: 0", len(tmp))
+	}
+
+	if err := d.SetFlags(first, "SRS"); err != nil {
+		t.Fatal(err)
+	}
+	if first.New || !strings.HasSuffix(first.Path, ":2,RS") || !first.HasFlag(FlagSeen) || first.HasFlag(FlagTrashed) {
+		t.Fatalf("got: %+v", first)
+	}
+	if got, want := subjects(t, d.Walk), "first:RS second:"; got != want {
+		t.Fatalf("got: %s, want: %s", got, want)
+	}
+
+	// Maildir++
+	archive, err := d.CreateFolder("Archive.2006")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.CreateFolder("../escape"); err == nil {
+		t.Fatal("want error for an invalid folder name")
+	}
+	if _, err := os.Stat(filepath.Join(root, ".Archive.2006", "maildirfolder")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := archive.Deliver(testMessage(t, "archived")); err != nil {
+		t.Fatal(err)
+	}
+	folders, err := d.Folders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(folders, ",") != "Archive.2006" {
+		t.Fatalf("got: %s, want: Archive.2006", folders)
+	}
+	if got, want := subjects(t, d.Folder("Archive.2006").Walk), "archived:"; got != want {
+		t.Fatalf("got: %s, want: %s", got, want)
+	}
+}
+
+func TestMH(t *testing.T) {
+	root, err := ioutil.TempDir("", "mh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	files := map[string]string{
+		"1":             "Subject: one\n\nbody\n",
+		"3":             "Subject: three\n\nbody\n",
+		"10":            "Subject: ten\n\nbody\n",
+		",9":            "Subject: deleted\n\nbody\n",
+		".mh_sequences": "unseen: 3 10\nflagged: 1-3\nreplied: 1\n",
+		"inbox/1":       "Subject: inbox\n\nbody\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(path), 0700)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := MH(root)
+	if got, want := subjects(t, f.Walk), "one:FRS ten: three:F"; got != want {
+		t.Fatalf("got: %s, want: %s", got, want)
+	}
+	m, err := f.Deliver(testMessage(t, "eleven"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Key != "11" || m.Flags != "" {
+		t.Fatalf("got: %+v, want: 11 without flags", m)
+	}
+	if got, want := subjects(t, f.Walk), "eleven: one:FRS ten: three:F"; got != want {
+		t.Fatalf("got: %s, want: %s", got, want)
+	}
+	if messages, _ := f.Messages(); len(messages) != 4 || messages[2].Key != "10" {
+		t.Fatalf("got: %+v", messages)
+	}
+	if folders, err := f.Folders(); err != nil || strings.Join(folders, ",") != "inbox" {
+		t.Fatalf("got: %s, %v", folders, err)
+	}
+	if got, want := subjects(t, f.Folder("inbox").Walk), "inbox:S"; got != want {
+		t.Fatalf("got: %s, want: %s", got, want)
+	}
+}
+
+func TestMHConcurrentDeliver(t *testing.T) {
+	root, err := ioutil.TempDir("", "mh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	f := MH(root)
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := f.DeliverMessage(strings.NewReader("Subject: concurrent\n\nbody\n"))
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	messages, err := f.Messages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != n {
+		t.Fatalf("got: %d messages, want: %d", len(messages), n)
+	}
+	for _, m := range messages {
+		if m.Flags != "" {
+			t.Fatalf("got: %+v, want: unseen", m)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, mhSequences+".lock")); !os.IsNotExist(err) {
+		t.Fatalf("got: %v, want: lock released", err)
+	}
+}
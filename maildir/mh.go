@@ -0,0 +1,259 @@
+package maildir
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/daogan/emime"
+	"github.com/pkg/errors"
+)
+
+const (
+	// mhSequences is the file of the named message sequences of a folder.
+	mhSequences = ".mh_sequences"
+	// mhLockTimeout is how long a sequences lock is waited for, an older
+	// lock file is considered stale.
+	mhLockTimeout = 30 * time.Second
+)
+
+// MH is the path of an MH folder, its messages are numbered files.
+type MH string
+
+// Messages returns the messages of f in number order. Flags are derived
+// from the unseen, flagged and replied sequences.
+func (f MH) Messages() ([]*Message, error) {
+	infos, err := ioutil.ReadDir(string(f))
+	if err != nil {
+		return nil, errors.Wrap(err, "mh")
+	}
+	seqs, err := f.sequences()
+	if err != nil {
+		return nil, err
+	}
+	var numbers []int
+	for _, info := range infos {
+		if n, err := strconv.Atoi(info.Name()); err == nil && n > 0 && !info.IsDir() {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	messages := make([]*Message, 0, len(numbers))
+	for _, n := range numbers {
+		key := strconv.Itoa(n)
+		m := &Message{Key: key, Path: filepath.Join(string(f), key)}
+		flags := ""
+		if !seqs["unseen"][n] {
+			flags += string(FlagSeen)
+		}
+		if seqs["flagged"][n] {
+			flags += string(FlagFlagged)
+		}
+		if seqs["replied"][n] {
+			flags += string(FlagReplied)
+		}
+		m.Flags = sortFlags(flags)
+		messages = append(messages, m)
+	}
+	return messages, nil
+}
+
+// Walk parses the messages of f in number order and calls fn for each.
+func (f MH) Walk(fn WalkFunc) error {
+	messages, err := f.Messages()
+	if err != nil {
+		return err
+	}
+	return walk(messages, fn)
+}
+
+// sequences parses .mh_sequences, `name: 1 3-5 8`, into message numbers
+// by sequence name.
+func (f MH) sequences() (map[string]map[int]bool, error) {
+	seqs := make(map[string]map[int]bool)
+	data, err := ioutil.ReadFile(filepath.Join(string(f), mhSequences))
+	if os.IsNotExist(err) {
+		return seqs, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "mh")
+	}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := s.Text()
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		set := make(map[int]bool)
+		for _, r := range strings.Fields(line[i+1:]) {
+			bounds := strings.SplitN(r, "-", 2)
+			lo, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				continue
+			}
+			hi := lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil || hi < lo {
+					continue
+				}
+			}
+			for n := lo; n <= hi; n++ {
+				set[n] = true
+			}
+		}
+		seqs[strings.TrimSpace(line[:i])] = set
+	}
+	return seqs, nil
+}
+
+// Folders returns the names of the subfolders of f, sorted.
+func (f MH) Folders() ([]string, error) {
+	infos, err := ioutil.ReadDir(string(f))
+	if err != nil {
+		return nil, errors.Wrap(err, "mh")
+	}
+	var names []string
+	for _, info := range infos {
+		if info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
+
+// Folder returns the subfolder name of f.
+func (f MH) Folder(name string) MH {
+	return MH(filepath.Join(string(f), name))
+}
+
+// Deliver encodes p into f.
+func (f MH) Deliver(p *emime.Part) (*Message, error) {
+	buf := &bytes.Buffer{}
+	if err := p.Encode(buf); err != nil {
+		return nil, err
+	}
+	return f.DeliverMessage(buf)
+}
+
+// DeliverMessage writes the message r to a temporary file of f, links it
+// to the next free number and adds it to the unseen sequence.
+func (f MH) DeliverMessage(r io.Reader) (*Message, error) {
+	if err := os.MkdirAll(string(f), 0700); err != nil {
+		return nil, errors.Wrap(err, "mh")
+	}
+	tmp, err := ioutil.TempFile(string(f), ",deliver")
+	if err != nil {
+		return nil, errors.Wrap(err, "mh")
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "mh")
+	}
+	messages, err := f.Messages()
+	if err != nil {
+		return nil, err
+	}
+	n := 1
+	if len(messages) > 0 {
+		n, _ = strconv.Atoi(messages[len(messages)-1].Key)
+		n++
+	}
+	// another delivery may take the number first
+	for {
+		key := strconv.Itoa(n)
+		path := filepath.Join(string(f), key)
+		err := os.Link(tmp.Name(), path)
+		if err == nil {
+			if err := f.addToSequence("unseen", n); err != nil {
+				return nil, err
+			}
+			return &Message{Key: key, Path: path}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, errors.Wrap(err, "mh")
+		}
+		n++
+	}
+}
+
+// lockSequences takes the .mh_sequences.lock file of f, as nmh does, and
+// returns the function which releases it.
+func (f MH) lockSequences() (func(), error) {
+	path := filepath.Join(string(f), mhSequences+".lock")
+	deadline := time.Now().Add(mhLockTimeout)
+	for {
+		lock, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			lock.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, errors.Wrap(err, "mh")
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > mhLockTimeout {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.Errorf("mh: %s is locked", path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// addToSequence adds message n to the sequence name of .mh_sequences,
+// the file is replaced atomically under the sequences lock.
+func (f MH) addToSequence(name string, n int) error {
+	unlock, err := f.lockSequences()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	data, err := ioutil.ReadFile(filepath.Join(string(f), mhSequences))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "mh")
+	}
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	found := false
+	for i, line := range lines {
+		if j := strings.IndexByte(line, ':'); j >= 0 && strings.TrimSpace(line[:j]) == name {
+			lines[i] = strings.TrimRight(line, " ") + " " + strconv.Itoa(n)
+			found = true
+			break
+		}
+	}
+	if !found {
+		lines = append(lines, name+": "+strconv.Itoa(n))
+	}
+	tmp, err := ioutil.TempFile(string(f), ",sequences")
+	if err != nil {
+		return errors.Wrap(err, "mh")
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.WriteString(strings.Join(lines, "\n") + "\n")
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(string(f), mhSequences))
+	}
+	return errors.Wrap(err, "mh")
+}
@@ -0,0 +1,119 @@
+// Package mbox reads and writes mbox files, RFC 4155, of messages parsed
+// by emime.
+//
+//	r := mbox.NewReader(f, mbox.Mboxrd)
+//	for {
+//		root, err := r.NextPart()
+//		if err == io.EOF {
+//			break
+//		}
+//		...
+//	}
+//
+// Each message starts with a From_ line, `From sender asctime-date`, and
+// ends with an empty line. The variants differ in how lines starting with
+// "From " inside messages are kept apart from From_ lines.
+package mbox
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// Format is an mbox variant.
+type Format int
+
+const (
+	// Mboxo quotes "From " lines as ">From ", which can not be told
+	// apart from quoted lines when read back.
+	Mboxo Format = iota
+	// Mboxrd quotes ">*From " lines with one more ">".
+	Mboxrd
+	// Mboxcl quotes like Mboxo and adds a Content-Length header.
+	Mboxcl
+	// Mboxcl2 does not quote, messages are framed by Content-Length.
+	Mboxcl2
+)
+
+func (f Format) String() string {
+	switch f {
+	case Mboxo:
+		return "mboxo"
+	case Mboxrd:
+		return "mboxrd"
+	case Mboxcl:
+		return "mboxcl"
+	case Mboxcl2:
+		return "mboxcl2"
+	}
+	return "Format(" + strconv.Itoa(int(f)) + ")"
+}
+
+// framed reports whether messages are framed by Content-Length.
+func (f Format) framed() bool {
+	return f == Mboxcl || f == Mboxcl2
+}
+
+const hContentLength = "Content-Length"
+
+var fromPrefix = []byte("From ")
+
+// quote returns line quoted for format.
+func quote(line []byte, format Format) []byte {
+	switch format {
+	case Mboxo, Mboxcl:
+		if !bytes.HasPrefix(line, fromPrefix) {
+			return line
+		}
+	case Mboxrd:
+		if !bytes.HasPrefix(bytes.TrimLeft(line, ">"), fromPrefix) {
+			return line
+		}
+	default:
+		return line
+	}
+	return append([]byte{'>'}, line...)
+}
+
+// unquote returns the original of a line quoted for format.
+func unquote(line []byte, format Format) []byte {
+	switch format {
+	case Mboxo, Mboxcl:
+		if bytes.HasPrefix(line, []byte(">From ")) {
+			return line[1:]
+		}
+	case Mboxrd:
+		if len(line) > 0 && line[0] == '>' && bytes.HasPrefix(bytes.TrimLeft(line, ">"), fromPrefix) {
+			return line[1:]
+		}
+	}
+	return line
+}
+
+// isBlank reports whether line is an empty line.
+func isBlank(line []byte) bool {
+	return len(bytes.TrimRight(line, "\r\n")) == 0 && len(line) > 0
+}
+
+// fieldName returns the name of a header line, or "".
+func fieldName(line []byte) string {
+	i := bytes.IndexByte(line, ':')
+	if i < 0 {
+		return ""
+	}
+	return strings.TrimSpace(string(line[:i]))
+}
+
+// contentLength returns the value of a Content-Length header line, or -1.
+func contentLength(line []byte) int64 {
+	if !strings.EqualFold(fieldName(line), hContentLength) {
+		return -1
+	}
+	value := line[bytes.IndexByte(line, ':')+1:]
+	n, err := strconv.ParseInt(strings.TrimSpace(string(value)), 10, 64)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,123 @@
+package mbox
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daogan/emime"
+)
+
+func TestReadWrite(t *testing.T) {
+	date := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	messages := []string{
+		"Subject: one\n\nFrom here\n>From there\n>>From everywhere\n\n",
+		"Subject: two\n\nno newline",
+		"Subject: three\r\n\r\nFrom crlf\r\n",
+		"Subject: four\n",
+	}
+	// Content-Length of the framed messages
+	lengths := map[Format][]string{Mboxcl: {"42", "11", "", "0"}, Mboxcl2: {"41", "11", "", "0"}}
+	for _, format := range []Format{Mboxo, Mboxrd, Mboxcl, Mboxcl2} {
+		buf := &bytes.Buffer{}
+		w := NewWriter(buf, format)
+		for _, msg := range messages {
+			if err := w.WriteMessage("alice@example.com", date, []byte(msg)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		r := NewReader(bytes.NewReader(buf.Bytes()), format)
+		for i, want := range messages {
+			msg, err := r.Next()
+			if err != nil {
+				t.Fatalf("%s %d: %v\n%s", format, i, err, buf)
+			}
+			if i == 2 {
+				// skip the rest of the message
+				continue
+			}
+			got, err := ioutil.ReadAll(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(want, "\n") {
+				want += "\n"
+			}
+			if format == Mboxo || format == Mboxcl {
+				// quoting is not reversible
+				want = strings.Replace(want, "\n>From", "\nFrom", 1)
+			}
+			if format.framed() {
+				want = strings.Replace(want, "\n\n", "\nContent-Length: "+lengths[format][i]+"\n\n", 1)
+				if i == 3 {
+					want += "Content-Length: 0\n\n"
+				}
+			}
+			if string(got) != want {
+				t.Fatalf("%s %d got: %q, want: %q\n%s", format, i, got, want, buf)
+			}
+			if r.From() != "alice@example.com Mon Jan  2 15:04:05 2006" {
+				t.Fatalf("got: %s", r.From())
+			}
+		}
+		if _, err := r.Next(); err != io.EOF {
+			t.Fatalf("%s got: %v, want: %v", format, err, io.EOF)
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	// Content-Length framing, the body has a From line and the last
+	// message has no Content-Length
+	cl2 := "From alice@example.com Mon Jan  2 15:04:05 2006\n" +
+		"Subject: one\nContent-Length: 27\n\nFrom the start\n\nFrom again\n\n" +
+		"From bob@example.com Mon Jan  2 15:04:05 2006\n" +
+		"Subject: two\n\nbody\n"
+	r := NewReader(strings.NewReader(cl2), Mboxcl2)
+	var got []string
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, p.Header.Get("Subject")+": "+string(p.Content))
+	}
+	want := []string{"one: From the start\n\nFrom again\n", "two: body\n"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("got: %q, want: %q", got, want)
+	}
+
+	r = NewReader(strings.NewReader("\nSubject: no From_ line\n\nbody\n"), Mboxrd)
+	if _, err := r.Next(); err != ErrMissingFrom {
+		t.Fatalf("got: %v, want: %v", err, ErrMissingFrom)
+	}
+
+	root, err := emime.NewBuilder().
+		From(&emime.Address{Address: "alice@example.com"}).
+		To(&emime.Address{Address: "bob@example.com"}).
+		Subject("parts").
+		Text("From the builder\n").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := NewWriter(buf, Mboxrd).WritePart(root); err != nil {
+		t.Fatal(err)
+	}
+	r = NewReader(buf, Mboxrd)
+	p, err := r.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p.Content) != "From the builder\r\n" || !strings.HasPrefix(r.From(), "alice@example.com ") {
+		t.Fatalf("got: %q, %q\n%s", p.Content, r.From(), buf)
+	}
+}
@@ -0,0 +1,189 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/daogan/emime"
+	"github.com/pkg/errors"
+)
+
+// ErrMissingFrom is returned when a message does not start with a From_
+// line.
+var ErrMissingFrom = errors.New("mbox: missing From_ line")
+
+// Reader iterates the messages of an mbox stream. Messages are streamed,
+// they are not buffered in memory.
+type Reader struct {
+	br     *bufio.Reader
+	format Format
+	from   string         // From_ line of the current message
+	next   []byte         // From_ line read at the end of the previous message
+	msg    *messageReader // current message, drained on next call
+	err    error
+}
+
+// NewReader returns a Reader of the mbox stream r in format.
+func NewReader(r io.Reader, format Format) *Reader {
+	return &Reader{br: bufio.NewReader(r), format: format}
+}
+
+// From returns the From_ line of the current message without "From ",
+// the envelope sender and the delivery date.
+func (r *Reader) From() string {
+	return r.from
+}
+
+// Next returns a reader of the next message, unquoted and without its
+// From_ line. The reader is valid until the next call. It returns io.EOF
+// at the end of the stream.
+func (r *Reader) Next() (io.Reader, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.msg != nil {
+		if _, err := io.Copy(ioutil.Discard, r.msg); err != nil {
+			r.err = err
+			return nil, err
+		}
+		r.msg = nil
+	}
+	line := r.next
+	r.next = nil
+	for line == nil {
+		b, err := r.br.ReadBytes('\n')
+		if len(b) > 0 && !isBlank(b) {
+			line = b
+			break
+		}
+		if err == io.EOF {
+			r.err = io.EOF
+			return nil, r.err
+		}
+		if err != nil {
+			r.err = errors.Wrap(err, "mbox")
+			return nil, r.err
+		}
+	}
+	if !bytes.HasPrefix(line, fromPrefix) {
+		r.err = ErrMissingFrom
+		return nil, r.err
+	}
+	r.from = strings.TrimRight(string(line[len(fromPrefix):]), "\r\n")
+
+	m := &messageReader{r: r, lines: r.br, delimited: true}
+	if r.format.framed() {
+		if err := m.readHeader(); err != nil {
+			r.err = err
+			return nil, err
+		}
+	}
+	r.msg = m
+	return m, nil
+}
+
+// NextPart parses the next message with emime.Parse. It returns io.EOF at
+// the end of the stream.
+func (r *Reader) NextPart() (*emime.Part, error) {
+	return r.NextPartWithOptions(nil)
+}
+
+// NextPartWithOptions parses the next message with emime.ParseWithOptions.
+func (r *Reader) NextPartWithOptions(opts *emime.ParseOptions) (*emime.Part, error) {
+	msg, err := r.Next()
+	if err != nil {
+		return nil, err
+	}
+	return emime.ParseWithOptions(msg, opts)
+}
+
+// messageReader reads one message. Delimited messages end before the next
+// From_ line, the empty line preceding it is dropped. Framed messages end
+// after Content-Length bytes of body.
+type messageReader struct {
+	r         *Reader
+	lines     *bufio.Reader
+	delimited bool
+	pending   []byte // unquoted bytes not read yet
+	held      []byte // empty line which may be the separator
+	done      bool
+}
+
+// readHeader reads the header of a framed message, the body is framed if
+// it has a Content-Length.
+func (m *messageReader) readHeader() error {
+	length := int64(-1)
+	for {
+		line, err := m.r.br.ReadBytes('\n')
+		if len(line) > 0 {
+			if bytes.HasPrefix(line, fromPrefix) {
+				// a message with a header only
+				m.r.next = line
+				m.done = true
+				return nil
+			}
+			if n := contentLength(line); n >= 0 {
+				length = n
+			}
+			m.pending = append(m.pending, unquote(line, m.r.format)...)
+		}
+		if err == io.EOF {
+			m.done = true
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "mbox")
+		}
+		if isBlank(line) {
+			break
+		}
+	}
+	if length >= 0 {
+		m.lines = bufio.NewReader(io.LimitReader(m.r.br, length))
+		m.delimited = false
+	}
+	return nil
+}
+
+func (m *messageReader) Read(p []byte) (int, error) {
+	for len(m.pending) == 0 {
+		if m.done {
+			return 0, io.EOF
+		}
+		if err := m.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, m.pending)
+	m.pending = m.pending[n:]
+	return n, nil
+}
+
+// fill reads the next line into pending.
+func (m *messageReader) fill() error {
+	line, err := m.lines.ReadBytes('\n')
+	switch {
+	case len(line) == 0:
+	case m.delimited && bytes.HasPrefix(line, fromPrefix):
+		m.r.next = line
+		m.done = true
+		return nil
+	case m.delimited && isBlank(line):
+		m.pending = append(m.pending, m.held...)
+		m.held = line
+	default:
+		m.pending = append(append(m.pending, m.held...), unquote(line, m.r.format)...)
+		m.held = nil
+	}
+	if err == io.EOF {
+		m.done = true
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "mbox")
+	}
+	return nil
+}
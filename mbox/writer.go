@@ -0,0 +1,122 @@
+package mbox
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/daogan/emime"
+	"github.com/pkg/errors"
+)
+
+// sender of From_ lines when the message has none, e.g. of bounces
+const mailerDaemon = "MAILER-DAEMON"
+
+// Writer appends messages to an mbox stream.
+type Writer struct {
+	w      io.Writer
+	format Format
+	// EncodeOptions are passed to Part.EncodeWithOptions by WritePart.
+	EncodeOptions *emime.EncodeOptions
+}
+
+// NewWriter returns a Writer of the mbox stream w in format.
+func NewWriter(w io.Writer, format Format) *Writer {
+	return &Writer{w: w, format: format}
+}
+
+// WritePart encodes p and appends it. The sender of the From_ line is the
+// Return-Path or From address, the date is the date of the message.
+func (w *Writer) WritePart(p *emime.Part) error {
+	buf := &bytes.Buffer{}
+	if err := p.EncodeWithOptions(buf, w.EncodeOptions); err != nil {
+		return err
+	}
+	date, _, err := p.Date()
+	if err != nil {
+		date = time.Now()
+	}
+	return w.WriteMessage(sender(p), date, buf.Bytes())
+}
+
+// sender returns the envelope sender of p.
+func sender(p *emime.Part) string {
+	if rp := p.Header.Get("Return-Path"); rp != "" {
+		if addrs, err := emime.ParseAddressList(rp); err == nil && len(addrs) == 1 {
+			return addrs[0].Address
+		}
+		if strings.TrimSpace(rp) == "<>" {
+			return mailerDaemon
+		}
+	}
+	if from, err := p.From(); err == nil && len(from) > 0 && from[0].Address != "" {
+		return from[0].Address
+	}
+	return mailerDaemon
+}
+
+// WriteMessage appends msg, an RFC 5322 message, with the From_ line of
+// sender and date. Lines starting with "From " are quoted as the format
+// requires, framed formats get a Content-Length header. The line breaks of
+// msg are kept, the From_ line and separator use the same.
+func (w *Writer) WriteMessage(sender string, date time.Time, msg []byte) error {
+	if sender == "" || strings.ContainsAny(sender, " \t\r\n") {
+		return errors.Errorf("mbox: invalid sender %q", sender)
+	}
+	eol := []byte("\n")
+	if i := bytes.IndexByte(msg, '\n'); i > 0 && msg[i-1] == '\r' {
+		eol = []byte("\r\n")
+	}
+	if len(msg) > 0 && !bytes.HasSuffix(msg, []byte("\n")) {
+		msg = append(msg[:len(msg):len(msg)], eol...)
+	}
+
+	out := &bytes.Buffer{}
+	out.WriteString("From " + sender + " " + date.UTC().Format(time.ANSIC))
+	out.Write(eol)
+	lines := bytes.SplitAfter(msg, []byte("\n"))
+	if w.format.framed() {
+		header, body := splitHeader(lines)
+		quoted := &bytes.Buffer{}
+		for _, line := range body {
+			quoted.Write(quote(line, w.format))
+		}
+		for _, line := range header {
+			out.Write(quote(line, w.format))
+		}
+		out.WriteString(hContentLength + ": " + strconv.Itoa(quoted.Len()))
+		out.Write(eol)
+		out.Write(eol)
+		out.Write(quoted.Bytes())
+	} else {
+		for _, line := range lines {
+			out.Write(quote(line, w.format))
+		}
+	}
+	out.Write(eol)
+	_, err := w.w.Write(out.Bytes())
+	return err
+}
+
+// splitHeader returns the header lines of a message without any
+// Content-Length field and the blank line, and the body lines.
+func splitHeader(lines [][]byte) (header, body [][]byte) {
+	skip := false
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		if isBlank(line) {
+			return header, lines[i+1:]
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			skip = strings.EqualFold(fieldName(line), hContentLength)
+		}
+		if !skip {
+			header = append(header, line)
+		}
+	}
+	return header, nil
+}
@@ -0,0 +1,248 @@
+package emime
+
+import (
+	"bytes"
+	"net/textproto"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	hDispositionNotificationTo = "Disposition-Notification-To"
+
+	ctDispositionNotification       = "message/disposition-notification"
+	ctGlobalDispositionNotification = "message/global-disposition-notification"
+	rtDispositionNotification       = "disposition-notification"
+)
+
+// MDN dispositions, RFC 8098 3.2.6.2.
+const (
+	DispositionDisplayed  = "displayed"
+	DispositionDeleted    = "deleted"
+	DispositionDispatched = "dispatched"
+	DispositionProcessed  = "processed"
+)
+
+var (
+	// ErrNotMDN is returned by Part.MDN when p is no disposition notification.
+	ErrNotMDN = errors.New("not a message disposition notification")
+	// ErrNoMDNRequest is returned when a message requests no MDN.
+	ErrNoMDNRequest = errors.New("no disposition notification requested")
+	// ErrMDNNotConfirmed is returned by NewMDN for an automatic notification
+	// which the user must confirm, see Part.MDNRequest.
+	ErrMDNNotConfirmed = errors.New("disposition notification not confirmed by the user")
+)
+
+// MDN is a message disposition notification, a read receipt, RFC 8098.
+type MDN struct {
+	ReportingUA       string
+	MDNGateway        string
+	OriginalRecipient string // Address, without the "rfc822;" type.
+	FinalRecipient    string
+	OriginalMessageID string
+	ActionMode        string   // "manual-action" or "automatic-action".
+	SendingMode       string   // "mdn-sent-manually" or "mdn-sent-automatically".
+	Disposition       string   // e.g. DispositionDisplayed.
+	Modifiers         []string // e.g. "error".
+	Errors            []string // Error fields.
+	Fields            textproto.MIMEHeader
+	// Original is the returned message, or only its header, nil if not
+	// returned.
+	Original *Part
+}
+
+// MDN finds and parses the message disposition notification of p.
+func (p *Part) MDN() (*MDN, error) {
+	report := p.findReport(rtDispositionNotification)
+	if report == nil {
+		if p.ContentType == ctDispositionNotification || p.ContentType == ctGlobalDispositionNotification {
+			return ParseDispositionNotification(p.Content)
+		}
+		return nil, ErrNotMDN
+	}
+	var mdn *MDN
+	var original *Part
+	for _, child := range report.Parts {
+		switch {
+		case child.ContentType == ctDispositionNotification || child.ContentType == ctGlobalDispositionNotification:
+			if mdn != nil {
+				continue
+			}
+			var err error
+			if mdn, err = ParseDispositionNotification(child.Content); err != nil {
+				return nil, err
+			}
+		case original == nil:
+			original = originalPart(child)
+		}
+	}
+	if mdn == nil {
+		return nil, errors.New("mdn: report without disposition notification")
+	}
+	mdn.Original = original
+	return mdn, nil
+}
+
+// ParseDispositionNotification parses the fields of a
+// `message/disposition-notification` part.
+func ParseDispositionNotification(content []byte) (*MDN, error) {
+	fields := parseFields(content)
+	disposition := fields.Get("Disposition")
+	if disposition == "" {
+		return nil, errors.New("mdn: missing Disposition field")
+	}
+	mdn := &MDN{
+		ReportingUA:       fields.Get("Reporting-UA"),
+		MDNGateway:        fieldValue(fields.Get("MDN-Gateway")),
+		OriginalRecipient: fieldValue(fields.Get("Original-Recipient")),
+		FinalRecipient:    fieldValue(fields.Get("Final-Recipient")),
+		OriginalMessageID: fields.Get("Original-Message-ID"),
+		Errors:            fields["Error"],
+		Fields:            fields,
+	}
+	// mode; type/modifier, modifier
+	disposition = strings.ToLower(strings.Join(strings.Fields(disposition), ""))
+	i := strings.IndexByte(disposition, ';')
+	if i < 0 {
+		return nil, errors.Errorf("mdn: invalid Disposition %q", fields.Get("Disposition"))
+	}
+	mode, typ := disposition[:i], disposition[i+1:]
+	if j := strings.IndexByte(mode, '/'); j >= 0 {
+		mdn.ActionMode, mdn.SendingMode = mode[:j], mode[j+1:]
+	} else {
+		mdn.ActionMode = mode
+	}
+	if j := strings.IndexByte(typ, '/'); j >= 0 {
+		for _, m := range strings.Split(typ[j+1:], ",") {
+			if m != "" {
+				mdn.Modifiers = append(mdn.Modifiers, m)
+			}
+		}
+		typ = typ[:j]
+	}
+	mdn.Disposition = typ
+	return mdn, nil
+}
+
+// MDNRequest returns the addresses p requests a disposition notification
+// to. confirm is set if the user should be asked before sending it, when
+// the addresses differ from the Return-Path, RFC 8098 2.1.
+func (p *Part) MDNRequest() (to []*Address, confirm bool, err error) {
+	if p.Header.Get(hDispositionNotificationTo) == "" {
+		return nil, false, ErrNoMDNRequest
+	}
+	to, err = p.AddressList(hDispositionNotificationTo)
+	if len(to) == 0 {
+		if err == nil {
+			err = ErrNoMDNRequest
+		}
+		return nil, false, err
+	}
+	returnPath := strings.Trim(strings.TrimSpace(p.Header.Get("Return-Path")), "<>")
+	confirm = len(to) > 1 || !strings.EqualFold(to[0].Address, returnPath)
+	return to, confirm, err
+}
+
+// MDNOptions configures NewMDN.
+type MDNOptions struct {
+	// ReportingUA names the user agent, e.g. "mail.example.com; Helpdesk 2.1".
+	ReportingUA string
+	// Automatic marks the disposition as done and sent without the user.
+	Automatic bool
+	// Confirmed is set when the user agreed to send the notification. It
+	// is required for automatic notifications of requests to confirm.
+	Confirmed bool
+	// Text is the human readable part, a default is used if empty.
+	Text string
+}
+
+// NewMDN returns a `multipart/report` disposition notification of the
+// parsed message original to its Disposition-Notification-To addresses,
+// sent by from:
+//
+//	root, err := emime.NewMDN(msg, &emime.Address{Address: "desk@example.com"},
+//		emime.DispositionDisplayed, nil)
+//
+// Notifications are never sent for notifications and bounces. Automatic
+// notifications of requests to confirm need opts.Confirmed.
+func NewMDN(original *Part, from *Address, disposition string, opts *MDNOptions) (*Part, error) {
+	if opts == nil {
+		opts = &MDNOptions{}
+	}
+	switch disposition {
+	case DispositionDisplayed, DispositionDeleted, DispositionDispatched, DispositionProcessed:
+	default:
+		return nil, errors.Errorf("mdn: invalid disposition %q", disposition)
+	}
+	if original.findReport(rtDispositionNotification, rtDeliveryStatus, rtGlobalStatus) != nil {
+		return nil, errors.New("mdn: original is a report")
+	}
+	to, confirm, err := original.MDNRequest()
+	if err != nil {
+		return nil, err
+	}
+	if confirm && opts.Automatic && !opts.Confirmed {
+		return nil, ErrMDNNotConfirmed
+	}
+
+	mode := "manual-action/MDN-sent-manually"
+	if opts.Automatic {
+		mode = "automatic-action/MDN-sent-automatically"
+	}
+	messageID := original.Header.Get("Message-Id")
+	fields := &bytes.Buffer{}
+	if opts.ReportingUA != "" {
+		fields.WriteString("Reporting-UA: " + opts.ReportingUA + "\r\n")
+	}
+	if rcpt := original.Header.Get("Original-Recipient"); rcpt != "" {
+		fields.WriteString("Original-Recipient: " + rcpt + "\r\n")
+	}
+	fields.WriteString("Final-Recipient: rfc822; " + from.Address + "\r\n")
+	if messageID != "" {
+		fields.WriteString("Original-Message-ID: " + messageID + "\r\n")
+	}
+	fields.WriteString("Disposition: " + mode + "; " + disposition + "\r\n")
+
+	subject := decodeHeader(original.Header.Get("Subject"))
+	text := opts.Text
+	if text == "" {
+		text = "The message sent on " + original.Header.Get("Date") + " to " + from.String() +
+			" with subject \"" + subject + "\" has been " + disposition + ".\r\n"
+	}
+	header := &bytes.Buffer{}
+	for _, field := range original.headerFields() {
+		header.WriteString(field[0] + ": " + field[1] + "\r\n")
+	}
+	body := newMultipart("report",
+		newTextPart(ctTextPlain, text),
+		newReportPart(ctDispositionNotification, fields.Bytes()),
+		newReportPart(ctRFC822Headers, header.Bytes()))
+	body.setHeader(hContentType, formatMediaType(ctReport, map[string]string{
+		"report-type": rtDispositionNotification,
+		hpBoundary:    body.Boundary,
+	}))
+
+	b := NewBuilder().From(from).To(to...).
+		Subject("Return Receipt (" + disposition + ") - " + subject)
+	if messageID != "" {
+		b.Header("In-Reply-To", messageID)
+		b.Header("References", strings.TrimSpace(original.Header.Get("References")+" "+messageID))
+	}
+	if opts.Automatic {
+		b.Header("Auto-Submitted", "auto-replied")
+	}
+	domain, err := b.domain()
+	if err != nil {
+		return nil, err
+	}
+	return b.message(body, domain), nil
+}
+
+// newReportPart returns a machine readable part of a report.
+func newReportPart(contentType string, content []byte) *Part {
+	p := &Part{Header: make(textproto.MIMEHeader), ContentType: contentType, Content: content}
+	p.rawCharset = true
+	p.setHeader(hContentType, contentType)
+	return p
+}
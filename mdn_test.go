@@ -0,0 +1,101 @@
+package emime
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseDispositionNotification(t *testing.T) {
+	content := "Reporting-UA: mail.example.com; Webmail\r\n" +
+		"Final-Recipient: rfc822; bob@example.com\r\n" +
+		"Original-Message-ID: <123@example.net>\r\n" +
+		"Disposition: manual-action/MDN-sent-manually;\r\n" +
+		"  displayed/error\r\n" +
+		"Error: mailbox quota\r\n"
+	mdn, err := ParseDispositionNotification([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mdn.ActionMode != "manual-action" || mdn.SendingMode != "mdn-sent-manually" ||
+		mdn.Disposition != DispositionDisplayed || strings.Join(mdn.Modifiers, ",") != "error" {
+		t.Fatalf("got: %+v", mdn)
+	}
+	if mdn.FinalRecipient != "bob@example.com" || mdn.OriginalMessageID != "<123@example.net>" ||
+		mdn.ReportingUA != "mail.example.com; Webmail" || len(mdn.Errors) != 1 {
+		t.Fatalf("got: %+v", mdn)
+	}
+	if _, err := ParseDispositionNotification([]byte("Final-Recipient: rfc822; a@b\r\n")); err == nil {
+		t.Fatal("want error for missing Disposition")
+	}
+}
+
+func TestNewMDN(t *testing.T) {
+	input := "From: Alice <alice@example.net>\r\n" +
+		"To: desk@example.com\r\n" +
+		"Return-Path: <alice@example.net>\r\n" +
+		"Disposition-Notification-To: Alice <alice@example.net>\r\n" +
+		"Message-ID: <123@example.net>\r\n" +
+		"Subject: help\r\n" +
+		"\r\n" +
+		"please\r\n"
+	original, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, confirm, err := original.MDNRequest()
+	if err != nil || confirm || len(to) != 1 || to[0].Address != "alice@example.net" {
+		t.Fatalf("got: %v %v %v", to, confirm, err)
+	}
+
+	from := &Address{Name: "Helpdesk", Address: "desk@example.com"}
+	root, err := NewMDN(original, from, DispositionDisplayed, &MDNOptions{ReportingUA: "example.com; Helpdesk"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := root.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := parsed.Header.Get(hTo); got != `Alice <alice@example.net>` {
+		t.Fatalf("got: %s, want: %s", got, `Alice <alice@example.net>`)
+	}
+	if got := parsed.Header.Get("In-Reply-To"); got != "<123@example.net>" {
+		t.Fatalf("got: %s, want: %s", got, "<123@example.net>")
+	}
+	mdn, err := parsed.MDN()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mdn.Disposition != DispositionDisplayed || mdn.ActionMode != "manual-action" ||
+		mdn.FinalRecipient != "desk@example.com" || mdn.OriginalMessageID != "<123@example.net>" {
+		t.Fatalf("got: %+v", mdn)
+	}
+	if mdn.Original == nil || mdn.Original.Header.Get("Subject") != "help" {
+		t.Fatalf("got: %+v", mdn.Original)
+	}
+
+	// no notifications of notifications
+	if _, err := NewMDN(parsed, from, DispositionDisplayed, nil); err == nil {
+		t.Fatal("want error for a report")
+	}
+	// a request to another address than the Return-Path
+	original.Header.Set(hDispositionNotificationTo, "bob@example.org")
+	if _, err := NewMDN(original, from, DispositionDisplayed, &MDNOptions{Automatic: true}); err != ErrMDNNotConfirmed {
+		t.Fatalf("got: %v, want: %v", err, ErrMDNNotConfirmed)
+	}
+	if _, err := NewMDN(original, from, DispositionDisplayed, &MDNOptions{Automatic: true, Confirmed: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewMDN(original, from, DispositionDisplayed, nil); err != nil {
+		t.Fatal(err)
+	}
+	original.Header.Del(hDispositionNotificationTo)
+	if _, err := NewMDN(original, from, DispositionDisplayed, nil); err != ErrNoMDNRequest {
+		t.Fatalf("got: %v, want: %v", err, ErrNoMDNRequest)
+	}
+}
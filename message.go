@@ -0,0 +1,269 @@
+package emime
+
+import (
+	"bufio"
+	"bytes"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// message types encapsulating a message, parsed like `message/rfc822`
+var messageTypes = map[string]bool{
+	ctRFC822:        true,
+	ctGlobal:        true,
+	ctGlobalHeaders: true,
+}
+
+// isMessage reports whether p encapsulates a message.
+func (p *Part) isMessage() bool {
+	return messageTypes[p.ContentType]
+}
+
+// isEncodedMessage reports whether p is a `message/global` or
+// `message/global-headers` with a transfer encoding, which RFC 6532 3.5
+// allows. Its child is parsed from the decoded body.
+func (p *Part) isEncodedMessage() bool {
+	if p.ContentType != ctGlobal && p.ContentType != ctGlobalHeaders {
+		return false
+	}
+	cte := lowerTrim(p.Header.Get(hContentEncoding))
+	return cte == cteQuotedPrintable || cte == cteBase64
+}
+
+// headerFields returns the header fields of p in order.
+func (p *Part) headerFields() [][2]string {
+	seen := make(map[string]int)
+	fields := make([][2]string, 0, len(p.HeaderKeys))
+	for _, k := range p.HeaderKeys {
+		ck := textproto.CanonicalMIMEHeaderKey(k)
+		values := p.Header[ck]
+		if seen[ck] >= len(values) {
+			continue
+		}
+		fields = append(fields, [2]string{k, values[seen[ck]]})
+		seen[ck]++
+	}
+	return fields
+}
+
+// ReassemblePartial reassembles a message fragmented into `message/partial`
+// parts, RFC 2046 5.2.2.2. The fragments may be given in any order, they
+// must share the id and be complete. The header fields of the first
+// fragment are combined with those of the enclosed message.
+func ReassemblePartial(fragments []*Part) (*Part, error) {
+	if len(fragments) == 0 {
+		return nil, errors.New("partial: no fragments")
+	}
+	byNumber := make(map[int]*Part)
+	id, total := "", 0
+	for _, p := range fragments {
+		if p.ContentType != ctPartial {
+			return nil, errors.Errorf("partial: unexpected Content-Type %q", p.ContentType)
+		}
+		_, params, err := parseMediaType(p.Header.Get(hContentType))
+		if err != nil {
+			return nil, errors.Wrap(err, "partial")
+		}
+		if id == "" {
+			id = params["id"]
+		}
+		if params["id"] == "" || params["id"] != id {
+			return nil, errors.Errorf("partial: id %q, want: %q", params["id"], id)
+		}
+		number, err := strconv.Atoi(params["number"])
+		if err != nil || number < 1 {
+			return nil, errors.Errorf("partial: invalid number %q", params["number"])
+		}
+		if _, ok := byNumber[number]; ok {
+			return nil, errors.Errorf("partial: duplicate number %d", number)
+		}
+		byNumber[number] = p
+		if params["total"] != "" {
+			if total, err = strconv.Atoi(params["total"]); err != nil {
+				return nil, errors.Errorf("partial: invalid total %q", params["total"])
+			}
+		}
+	}
+	if total == 0 {
+		return nil, errors.Errorf("partial: total of %q unknown", id)
+	}
+	numbers := make([]int, 0, len(byNumber))
+	for n := range byNumber {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	if len(numbers) != total || numbers[len(numbers)-1] != total {
+		return nil, errors.Errorf("partial: %d of %d fragments of %q", len(numbers), total, id)
+	}
+
+	enclosed := &bytes.Buffer{}
+	for _, n := range numbers {
+		enclosed.Write(byNumber[n].Content)
+	}
+	header, body := splitMessage(enclosed.Bytes())
+
+	buf := &bytes.Buffer{}
+	// fields of the first enclosing message, except the ones below
+	for _, field := range byNumber[1].headerFields() {
+		if !isPartialField(field[0]) {
+			buf.WriteString(field[0] + ": " + field[1] + "\r\n")
+		}
+	}
+	// fields of the enclosed message, only the ones below
+	for _, field := range splitHeaderFields(header) {
+		if isPartialField(field[:strings.IndexByte(field, ':')]) {
+			buf.WriteString(field)
+		}
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body)
+	return Parse(buf)
+}
+
+// isPartialField reports whether the header field named key is taken from
+// the enclosed message of fragments.
+func isPartialField(key string) bool {
+	switch ck := textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(key)); ck {
+	case "Subject", "Message-Id", "Encrypted", "Mime-Version":
+		return true
+	default:
+		return strings.HasPrefix(ck, "Content-")
+	}
+}
+
+// splitMessage splits a message into its header and body at the first
+// blank line.
+func splitMessage(msg []byte) (header, body []byte) {
+	for i := 0; i < len(msg); {
+		j := bytes.IndexByte(msg[i:], '\n')
+		if j < 0 {
+			break
+		}
+		line := msg[i : i+j+1]
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			return msg[:i], msg[i+j+1:]
+		}
+		i += j + 1
+	}
+	return msg, nil
+}
+
+// splitHeaderFields splits header into its fields, continuation lines
+// included, lines without a colon are dropped.
+func splitHeaderFields(header []byte) []string {
+	var fields []string
+	for _, line := range strings.SplitAfter(string(header), "\n") {
+		switch {
+		case line == "":
+		case line[0] == ' ' || line[0] == '\t':
+			if len(fields) > 0 {
+				fields[len(fields)-1] += line
+			}
+		case strings.IndexByte(line, ':') > 0:
+			fields = append(fields, line)
+		}
+	}
+	for i, field := range fields {
+		if !strings.HasSuffix(field, "\n") {
+			fields[i] = field + "\r\n"
+		}
+	}
+	return fields
+}
+
+// ExternalBody is a `message/external-body` part, RFC 2046 5.2.3.
+type ExternalBody struct {
+	AccessType string            // Access type, e.g. "url", "anon-ftp" or "mail-server".
+	Params     map[string]string // Content-Type parameters, e.g. "name", "site", "url".
+	Header     textproto.MIMEHeader
+	Body       []byte // Phantom body, e.g. the commands of the mail-server access type.
+}
+
+// ExternalBody returns the access parameters of a `message/external-body`
+// part, and the header of the external data.
+func (p *Part) ExternalBody() (*ExternalBody, error) {
+	if p.ContentType != ctExternalBody {
+		return nil, errors.Errorf("not %s: %q", ctExternalBody, p.ContentType)
+	}
+	_, params, err := parseMediaType(p.Header.Get(hContentType))
+	if err != nil {
+		return nil, errors.Wrap(err, "external-body")
+	}
+	ext := &ExternalBody{AccessType: strings.ToLower(params["access-type"]), Params: params}
+	header, body := splitMessage(p.Content)
+	ext.Header = parseFields(header)
+	ext.Body = body
+	return ext, nil
+}
+
+// DeliveryStatus is a parsed `message/delivery-status` part, RFC 3464.
+type DeliveryStatus struct {
+	// Per-message fields, e.g. Reporting-MTA and Arrival-Date.
+	Message textproto.MIMEHeader
+	// Per-recipient fields, e.g. Final-Recipient, Action and Status.
+	Recipients []textproto.MIMEHeader
+}
+
+// DeliveryStatus parses a `message/delivery-status` or
+// `message/global-delivery-status` part.
+func (p *Part) DeliveryStatus() (*DeliveryStatus, error) {
+	if p.ContentType != ctDeliveryStatus && p.ContentType != ctGlobalStatus {
+		return nil, errors.Errorf("not %s: %q", ctDeliveryStatus, p.ContentType)
+	}
+	return ParseDeliveryStatus(p.Content)
+}
+
+// ParseDeliveryStatus parses the field groups of a delivery status, the
+// first group is per-message, the following ones per-recipient.
+func ParseDeliveryStatus(content []byte) (*DeliveryStatus, error) {
+	groups := splitFieldGroups(content)
+	if len(groups) == 0 {
+		return nil, errors.New("delivery-status: no fields")
+	}
+	ds := &DeliveryStatus{Message: groups[0]}
+	ds.Recipients = groups[1:]
+	return ds, nil
+}
+
+// splitFieldGroups parses groups of header fields separated by blank lines.
+func splitFieldGroups(content []byte) []textproto.MIMEHeader {
+	var groups []textproto.MIMEHeader
+	var group []byte
+	r := bufio.NewReader(bytes.NewReader(content))
+	for {
+		line, err := r.ReadBytes('\n')
+		blank := len(bytes.TrimSpace(line)) == 0
+		if !blank {
+			group = append(group, line...)
+			if !bytes.HasSuffix(line, []byte{'\n'}) {
+				group = append(group, crnl...)
+			}
+		}
+		if (blank || err != nil) && len(group) > 0 {
+			if fields := parseFields(group); len(fields) > 0 {
+				groups = append(groups, fields)
+			}
+			group = nil
+		}
+		if err != nil {
+			break
+		}
+	}
+	return groups
+}
+
+// parseFields tolerantly parses header fields, lines without a colon are
+// dropped.
+func parseFields(header []byte) textproto.MIMEHeader {
+	fields := make(textproto.MIMEHeader)
+	for _, field := range splitHeaderFields(header) {
+		i := strings.IndexByte(field, ':')
+		value := strings.Join(strings.Fields(unfold(field[i+1:])), " ")
+		fields.Add(strings.TrimSpace(field[:i]), value)
+	}
+	return fields
+}
@@ -0,0 +1,206 @@
+package emime
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestParseMessageGlobal(t *testing.T) {
+	input := "Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: message/global\r\n" +
+		"\r\n" +
+		"Subject: Grüße\r\n" +
+		"\r\n" +
+		"global body\r\n" +
+		"--b\r\n" +
+		"Content-Type: message/global-headers\r\n" +
+		"\r\n" +
+		"Subject: 日本\r\n" +
+		"--b--\r\n"
+	root, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	global, headers := root.Parts[0], root.Parts[1]
+	if len(global.Parts) != 1 || global.Parts[0].Header.Get("Subject") != "Grüße" ||
+		string(global.Parts[0].Content) != "global body" {
+		t.Fatalf("got: %+v", global.Parts)
+	}
+	if len(headers.Parts) != 1 || headers.Parts[0].Header.Get("Subject") != "日本" {
+		t.Fatalf("got: %+v", headers.Parts)
+	}
+	buf := &bytes.Buffer{}
+	if err := root.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "global body") {
+		t.Fatalf("got: %q", buf.String())
+	}
+}
+
+func TestParseEncodedMessageGlobal(t *testing.T) {
+	encoded := map[string]string{
+		"quoted-printable": "Subject: =C3=A9t=C3=A9\r\nContent-Type: text/plain; charset=3Dutf-8\r\n\r\nh=C3=A9llo\r\n",
+		"base64":           base64.StdEncoding.EncodeToString([]byte("Subject: été\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nhéllo")) + "\r\n",
+	}
+	for cte, body := range encoded {
+		input := "Content-Type: multipart/mixed; boundary=b\r\n" +
+			"\r\n" +
+			"--b\r\n" +
+			"Content-Type: message/global\r\n" +
+			"Content-Transfer-Encoding: " + cte + "\r\n" +
+			"\r\n" +
+			body +
+			"--b--\r\n"
+		root, err := ParseWithOptions(strings.NewReader(input), &ParseOptions{KeepRaw: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		global := root.Parts[0]
+		if len(global.Parts) != 1 || global.Parts[0].Header.Get("Subject") != "été" ||
+			string(global.Parts[0].Content) != "héllo" {
+			t.Fatalf("%s got: %q, %q", cte, global.Parts[0].Header.Get("Subject"), global.Parts[0].Content)
+		}
+		buf := &bytes.Buffer{}
+		if err := root.Encode(buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != input {
+			t.Fatalf("%s got: %q, want: %q", cte, buf.String(), input)
+		}
+
+		global.Parts[0].Content = []byte("changed")
+		buf.Reset()
+		if err := root.Encode(buf); err != nil {
+			t.Fatal(err)
+		}
+		root, err = Parse(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(root.Parts[0].Parts[0].Content); got != "changed" {
+			t.Fatalf("%s got: %q, want: changed", cte, got)
+		}
+	}
+}
+
+func TestReassemblePartial(t *testing.T) {
+	fragment := func(number, total string, body string) string {
+		ct := `Content-Type: message/partial; id="ABC@host.com"; number=` + number
+		if total != "" {
+			ct += "; total=" + total
+		}
+		return "X-Weird-Header-1: Foo\r\n" +
+			"From: Bill@host.com\r\n" +
+			"Subject: Audio mail (part " + number + " of 3)\r\n" +
+			"Message-ID: <id" + number + "@host.com>\r\n" +
+			"MIME-Version: 1.0\r\n" +
+			ct + "\r\n" +
+			"\r\n" +
+			body
+	}
+	bodies := []string{
+		"X-Weird-Header-1: Bar\r\n" +
+			"X-Weird-Header-2: Hello\r\n" +
+			"Message-ID: <anotherid@foo.com>\r\n" +
+			"Subject: Audio mail\r\n" +
+			"MIME-Version: 1.0\r\n" +
+			"Content-Type: text/plain\r\n" +
+			"\r\n" +
+			"first ",
+		"second ",
+		"third",
+	}
+	var fragments []*Part
+	for _, i := range []int{2, 0, 1} {
+		total := ""
+		if i == 2 {
+			total = "3"
+		}
+		p, err := Parse(strings.NewReader(fragment(string(rune('1'+i)), total, bodies[i])))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fragments = append(fragments, p)
+	}
+	root, err := ReassemblePartial(fragments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(root.Content) != "first second third" {
+		t.Fatalf("got: %q, want: %q", root.Content, "first second third")
+	}
+	want := "X-Weird-Header-1,From,Message-ID,Subject,MIME-Version,Content-Type"
+	if got := strings.Join(root.HeaderKeys, ","); got != want {
+		t.Fatalf("got: %s, want: %s", got, want)
+	}
+	if root.Header.Get("X-Weird-Header-1") != "Foo" || root.Header.Get("Message-Id") != "<anotherid@foo.com>" {
+		t.Fatalf("got: %v", root.Header)
+	}
+
+	if _, err := ReassemblePartial(fragments[:2]); err == nil {
+		t.Fatal("want error for missing fragment")
+	}
+}
+
+func TestExternalBody(t *testing.T) {
+	input := "Content-Type: message/external-body; access-type=URL;\r\n" +
+		"\tURL=\"ftp://ftp.example.com/pub/file.tar.gz\"; size=1024\r\n" +
+		"\r\n" +
+		"Content-Type: application/x-tar\r\n" +
+		"Content-ID: <file@example.com>\r\n" +
+		"\r\n"
+	root, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ext, err := root.ExternalBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ext.AccessType != "url" || ext.Params["url"] != "ftp://ftp.example.com/pub/file.tar.gz" || ext.Params["size"] != "1024" {
+		t.Fatalf("got: %+v", ext)
+	}
+	if ext.Header.Get("Content-Type") != "application/x-tar" {
+		t.Fatalf("got: %v", ext.Header)
+	}
+}
+
+func TestDeliveryStatus(t *testing.T) {
+	input := "Content-Type: message/delivery-status\r\n" +
+		"\r\n" +
+		"Reporting-MTA: dns; mx.example.com\r\n" +
+		"Arrival-Date: Mon, 2 Jan 2006 15:04:05 +0000\r\n" +
+		"\r\n" +
+		"Final-Recipient: rfc822; a@example.net\r\n" +
+		"Action: failed\r\n" +
+		"Status: 5.1.1\r\n" +
+		"Diagnostic-Code: smtp; 550 5.1.1 user unknown,\r\n" +
+		"  try again later\r\n" +
+		"\r\n" +
+		"\r\n" +
+		"Final-Recipient: rfc822; b@example.net\r\n" +
+		"Action: delayed\r\n" +
+		"Status: 4.4.1\r\n"
+	root, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := root.DeliveryStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds.Message.Get("Reporting-MTA") != "dns; mx.example.com" || len(ds.Recipients) != 2 {
+		t.Fatalf("got: %+v", ds)
+	}
+	if got := ds.Recipients[0].Get("Diagnostic-Code"); got != "smtp; 550 5.1.1 user unknown, try again later" {
+		t.Fatalf("got: %q", got)
+	}
+	if got := ds.Recipients[1].Get("Status"); got != "4.4.1" {
+		t.Fatalf("got: %q", got)
+	}
+}
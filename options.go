@@ -0,0 +1,98 @@
+package emime
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ParseOptions controls how an email is parsed.
+// A zero limit means no limit.
+type ParseOptions struct {
+	// Strict rejects any RFC 5322/2045/2046 violation with a *DefectError
+	// instead of recovering from it. It additionally checks line endings,
+	// line lengths, 8-bit headers and content against the encoding, the
+	// message is validated for a transport without BINARYMIME.
+	Strict bool
+	// SMTPUTF8 allows UTF-8 headers (RFC 6532) in strict mode.
+	SMTPUTF8 bool
+	// RawCharset keeps text content in its declared charset instead of
+	// decoding it to UTF-8.
+	RawCharset bool
+	// KeepRaw keeps a copy of the original bytes of the message, for
+	// Part.Raw and for Encode to write unmodified parts verbatim. Parse
+	// only, Reader never keeps them.
+	KeepRaw bool
+
+	MaxDepth       int   // Max nesting depth of parts, the root is at depth 0.
+	MaxParts       int   // Max number of parts in the tree.
+	MaxHeaderBytes int   // Max header bytes of a single part.
+	MaxHeaderLines int   // Max header lines of a single part.
+	MaxBodySize    int64 // Max decoded body size of a single part.
+	MaxMessageSize int64 // Max total size of the message.
+}
+
+// ErrLimitExceeded is matched by every *LimitError with errors.Is.
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// LimitError is returned when parsing hits one of the ParseOptions limits.
+type LimitError struct {
+	Limit  string // Name of the ParseOptions field, e.g. "MaxDepth".
+	Max    int64  // Configured value of the limit.
+	PartID string // Part being parsed when the limit was hit.
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("limit: %s of %d exceeded, part: %q", e.Limit, e.Max, e.PartID)
+}
+
+// Is reports whether target is ErrLimitExceeded.
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+func isLimitError(err error) bool {
+	var lerr *LimitError
+	return errors.As(err, &lerr)
+}
+
+// limitReader fails with a LimitError once more than max bytes are read.
+type limitReader struct {
+	r     io.Reader
+	n     int64
+	max   int64
+	limit string
+	part  *Part
+}
+
+func newLimitReader(r io.Reader, max int64, limit string, part *Part) io.Reader {
+	if max <= 0 {
+		return r
+	}
+	return &limitReader{r: r, max: max, limit: limit, part: part}
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.n > l.max {
+		return 0, l.err()
+	}
+	// read one byte past the limit to tell "exactly max" from "too large"
+	if rest := l.max - l.n + 1; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.max {
+		return n - int(l.n-l.max), l.err()
+	}
+	return n, err
+}
+
+func (l *limitReader) err() error {
+	partID := ""
+	if l.part != nil {
+		partID = l.part.PartID
+	}
+	return &LimitError{Limit: l.limit, Max: l.max, PartID: partID}
+}
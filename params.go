@@ -0,0 +1,337 @@
+package emime
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/daogan/emime/internal/coding"
+	"github.com/pkg/errors"
+)
+
+// max length of an encoded parameter value before it is continued
+const maxParamLength = 60
+
+// parseMediaType parses a media type value and its parameters, like
+// mime.ParseMediaType, with full RFC 2231 support: continuations are
+// joined before the value is decoded from any charset of internal/coding.
+func parseMediaType(v string) (string, map[string]string, error) {
+	base := v
+	if i := strings.IndexByte(v, ';'); i >= 0 {
+		base = v[:i]
+	}
+	mediatype := strings.ToLower(strings.TrimSpace(base))
+	if err := checkMediaType(mediatype); err != nil {
+		return "", nil, err
+	}
+
+	params := make(map[string]string)
+	// parameter name -> section name -> value for names with '*'
+	var continuation map[string]map[string]string
+	v = v[len(base):]
+	for len(v) > 0 {
+		v = strings.TrimLeftFunc(v, unicode.IsSpace)
+		if len(v) == 0 {
+			break
+		}
+		key, value, rest := consumeMediaParam(v)
+		if key == "" {
+			if strings.TrimSpace(rest) == ";" {
+				// ignore trailing semicolons
+				break
+			}
+			return mediatype, nil, errors.Errorf("invalid media parameter %q", rest)
+		}
+		pmap := params
+		if i := strings.IndexByte(key, '*'); i >= 0 {
+			if continuation == nil {
+				continuation = make(map[string]map[string]string)
+			}
+			name := key[:i]
+			if pmap = continuation[name]; pmap == nil {
+				pmap = make(map[string]string)
+				continuation[name] = pmap
+			}
+		}
+		if old, ok := pmap[key]; ok && old != value {
+			return "", nil, errors.Errorf("duplicate parameter name %q", key)
+		}
+		pmap[key] = value
+		v = rest
+	}
+
+	for name, sections := range continuation {
+		if value, ok := decode2231(name, sections); ok {
+			params[name] = value
+		}
+	}
+	return mediatype, params, nil
+}
+
+// decode2231 joins the sections of parameter name and decodes the value.
+func decode2231(name string, sections map[string]string) (string, bool) {
+	// single encoded value, `name*=charset'lang'value`
+	if v, ok := sections[name+"*"]; ok {
+		charset, value, ok := split2231(v)
+		if !ok {
+			return "", false
+		}
+		return decodeCharsetBytes(charset, percentUnescape(value)), true
+	}
+	var charset string
+	var buf []byte
+	for n := 0; ; n++ {
+		section := name + "*" + strconv.Itoa(n)
+		if v, ok := sections[section]; ok {
+			buf = append(buf, v...)
+			continue
+		}
+		v, ok := sections[section+"*"]
+		if !ok {
+			if n == 0 {
+				return "", false
+			}
+			break
+		}
+		if n == 0 {
+			if charset, v, ok = split2231(v); !ok {
+				return "", false
+			}
+		}
+		buf = append(buf, percentUnescape(v)...)
+	}
+	return decodeCharsetBytes(charset, buf), true
+}
+
+// split2231 splits `charset'language'value`.
+func split2231(v string) (charset, value string, ok bool) {
+	parts := strings.SplitN(v, "'", 3)
+	if len(parts) != 3 {
+		return "", "", false
+	}
+	return parts[0], parts[2], true
+}
+
+func percentUnescape(s string) []byte {
+	buf := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			b, _ := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			buf = append(buf, byte(b))
+			i += 2
+			continue
+		}
+		// keep malformed escapes
+		buf = append(buf, s[i])
+	}
+	return buf
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// decodeCharsetBytes decodes b to UTF-8, unknown charsets are kept as is.
+func decodeCharsetBytes(charset string, b []byte) string {
+	if charset == "" || coding.IsUTF8(charset) {
+		return string(b)
+	}
+	r, err := coding.NewCharsetReader(charset, bytes.NewReader(b))
+	if err != nil {
+		return string(b)
+	}
+	dec, err := ioutil.ReadAll(r)
+	if err != nil {
+		return string(b)
+	}
+	return string(dec)
+}
+
+func checkMediaType(s string) error {
+	typ, rest := consumeToken(s, false)
+	if typ == "" {
+		return errors.New("no media type")
+	}
+	if rest == "" {
+		return nil
+	}
+	if !strings.HasPrefix(rest, "/") {
+		return errors.New("expected slash after first token")
+	}
+	subtype, rest := consumeToken(rest[1:], false)
+	if subtype == "" {
+		return errors.New("expected token after slash")
+	}
+	if rest != "" {
+		return errors.New("unexpected content after media subtype")
+	}
+	return nil
+}
+
+// consumeMediaParam consumes `; attribute=value` from v.
+func consumeMediaParam(v string) (param, value, rest string) {
+	rest = strings.TrimLeftFunc(v, unicode.IsSpace)
+	if !strings.HasPrefix(rest, ";") {
+		return "", "", v
+	}
+	rest = strings.TrimLeftFunc(rest[1:], unicode.IsSpace)
+	param, rest = consumeToken(rest, false)
+	param = strings.ToLower(param)
+	if param == "" {
+		return "", "", v
+	}
+	rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+	if !strings.HasPrefix(rest, "=") {
+		return "", "", v
+	}
+	rest = strings.TrimLeftFunc(rest[1:], unicode.IsSpace)
+	value, rest2 := consumeValue(rest)
+	if value == "" && rest2 == rest {
+		return "", "", v
+	}
+	return param, value, rest2
+}
+
+// consumeToken consumes a token, 8-bit characters are allowed in values.
+func consumeToken(v string, value bool) (token, rest string) {
+	i := strings.IndexFunc(v, func(r rune) bool {
+		if value && r >= 0x80 {
+			return false
+		}
+		return !isTokenChar(r)
+	})
+	if i == -1 {
+		return v, ""
+	}
+	return v[:i], v[i:]
+}
+
+// consumeValue consumes a token or a quoted-string.
+func consumeValue(v string) (value, rest string) {
+	if v == "" {
+		return "", v
+	}
+	if v[0] != '"' {
+		return consumeToken(v, true)
+	}
+	buf := &strings.Builder{}
+	for i := 1; i < len(v); i++ {
+		c := v[i]
+		if c == '"' {
+			return buf.String(), v[i+1:]
+		}
+		// Unnecessary backslash escapes are kept as literal backslashes,
+		// MSIE does not escape backslashes of file paths.
+		if c == '\\' && i+1 < len(v) && isTSpecial(rune(v[i+1])) {
+			buf.WriteByte(v[i+1])
+			i++
+			continue
+		}
+		if c == '\r' || c == '\n' {
+			return "", v
+		}
+		buf.WriteByte(c)
+	}
+	// no end quote
+	return "", v
+}
+
+func isTSpecial(r rune) bool {
+	return strings.ContainsRune(`()<>@,;:\"/[]?=`, r)
+}
+
+func isTokenChar(r rune) bool {
+	return r > 0x20 && r < 0x7f && !isTSpecial(r)
+}
+
+// isAttrChar reports whether c needs no percent-encoding in RFC 2231.
+func isAttrChar(c byte) bool {
+	return isTokenChar(rune(c)) && c != '*' && c != '\'' && c != '%'
+}
+
+// formatMediaType formats a media type value with its parameters,
+// non-ASCII values are encoded as RFC 2231 UTF-8 parameters,
+// long values are split into continuations.
+func formatMediaType(mtype string, params map[string]string) string {
+	var sb strings.Builder
+	sb.WriteString(strings.ToLower(mtype))
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := params[name]
+		if !hasNonASCII(value) {
+			sb.WriteString("; " + name + "=")
+			if value != "" && strings.IndexFunc(value, func(r rune) bool { return !isTokenChar(r) }) < 0 {
+				sb.WriteString(value)
+			} else {
+				sb.WriteString(quoteString(value))
+			}
+			continue
+		}
+		sections := encode2231(value)
+		if len(sections) == 1 {
+			sb.WriteString("; " + name + "*=" + sections[0])
+			continue
+		}
+		for i, section := range sections {
+			fmt.Fprintf(&sb, "; %s*%d*=%s", name, i, section)
+		}
+	}
+	return sb.String()
+}
+
+// encode2231 percent-encodes value into sections, the first of which
+// carries the charset.
+func encode2231(value string) []string {
+	var sections []string
+	var sb strings.Builder
+	sb.WriteString("utf-8''")
+	for _, r := range value {
+		var enc string
+		if r < 0x80 && isAttrChar(byte(r)) {
+			enc = string(r)
+		} else {
+			var b [4]byte
+			n := copy(b[:], string(r))
+			for _, c := range b[:n] {
+				enc += fmt.Sprintf("%%%02X", c)
+			}
+		}
+		// characters are not split across sections
+		if sb.Len()+len(enc) > maxParamLength && sb.Len() > 0 {
+			sections = append(sections, sb.String())
+			sb.Reset()
+		}
+		sb.WriteString(enc)
+	}
+	return append(sections, sb.String())
+}
+
+func quoteString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(s[i])
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+func hasNonASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package emime
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseMediaType2231(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`attachment; filename="plain.txt"`, "plain.txt"},
+		{`attachment; filename*=utf-8''%E6%97%A5%E6%9C%AC.pdf`, "日本.pdf"},
+		{`attachment; filename*=UTF-8'en'a%20b.txt; filename="fallback"`, "a b.txt"},
+		// continuation split in the middle of a GBK character
+		{`attachment; filename*0*=gbk''%D6; filename*1*=%D0%CE%C4.doc`, "中文.doc"},
+		// mixed encoded and plain sections
+		{`attachment; filename*0*=iso-2022-jp''%1B%24B%46%7C%4B%5C%1B%28B; filename*1=".txt"`, "日本.txt"},
+		{`attachment; filename*0="long "; filename*1="name.txt"`, "long name.txt"},
+		{`attachment; filename*0*=shift_jis''%93%FA%96%7B; filename*1*=.csv`, "日本.csv"},
+	}
+	for _, tt := range tests {
+		_, params, err := parseMediaType(tt.input)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.input, err)
+		}
+		if params["filename"] != tt.want {
+			t.Errorf("got: %q, want: %q", params["filename"], tt.want)
+		}
+	}
+}
+
+func TestFormatMediaType2231(t *testing.T) {
+	name := strings.Repeat("日本語のファイル名", 3) + ".pdf"
+	value := formatMediaType("attachment", map[string]string{"filename": name})
+	if !strings.HasPrefix(value, "attachment; filename*0*=utf-8''%E6%97%A5") {
+		t.Fatalf("got: %s", value)
+	}
+	mtype, params, err := parseMediaType(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mtype != "attachment" || params["filename"] != name {
+		t.Fatalf("got: %q %q, want: %q", mtype, params["filename"], name)
+	}
+}
+
+func TestEncodeFileName2231(t *testing.T) {
+	input := "Content-Type: application/pdf\r\n" +
+		"Content-Disposition: attachment; filename=\"日本.pdf\"\r\n" +
+		"\r\n" +
+		"data"
+	root, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.FileName != "日本.pdf" {
+		t.Fatalf("got: %q, want: %q", root.FileName, "日本.pdf")
+	}
+	buf := &bytes.Buffer{}
+	if err := root.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "Content-Disposition: attachment; filename*=utf-8''%E6%97%A5%E6%9C%AC.pdf\r\n"
+	if !strings.Contains(buf.String(), want) {
+		t.Fatalf("got: %q, want: %q", buf.String(), want)
+	}
+}
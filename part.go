@@ -1,15 +1,13 @@
 package emime
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/base64"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"mime"
 	"mime/quotedprintable"
 	"net/textproto"
-	"strconv"
 	"strings"
 
 	"github.com/daogan/emime/internal/coding"
@@ -27,16 +25,31 @@ type Part struct {
 	FileName    string
 	Charset     string
 
+	// Content is the decoded content, text content is decoded to UTF-8
+	// unless ParseOptions.RawCharset is set.
 	Content []byte
+	// RawContent is the text content in the declared Charset,
+	// before it was decoded to UTF-8.
+	RawContent []byte
 
 	HeaderKeys []string
 
+	// Defects found while parsing this part.
+	Defects []*Defect
+
 	Parent *Part
 	Parts  []*Part
+
+	offset        int64    // message offset of the part
+	headerEnd     int64    // message offset of the body
+	end           int64    // message offset of the end of the part
+	headerOffsets []int64  // message offsets of HeaderKeys
+	rawCharset    bool     // text Content is not decoded to UTF-8
+	raw           *rawPart // original bytes, nil if not kept
 }
 
-func (p *Part) setupHeaders(r *bufio.Reader, defaultContentType string) error {
-	header, err := readHeader(r, p)
+func (p *Part) setupHeaders(r *posReader, defaultContentType string, opts *ParseOptions) error {
+	header, err := readHeader(r, p, opts)
 	if err != nil {
 		return err
 	}
@@ -46,12 +59,17 @@ func (p *Part) setupHeaders(r *bufio.Reader, defaultContentType string) error {
 	if ctype == "" {
 		ctype = defaultContentType
 	}
-	mtype, tparams, err := mime.ParseMediaType(ctype)
+	mtype, tparams, err := parseMediaType(ctype)
 	if err != nil {
-		mtype, tparams, err = mime.ParseMediaType(fixMediaType(ctype))
+		mtype, tparams, err = parseMediaType(fixMediaType(ctype))
 		if err != nil {
 			return fmt.Errorf("media type, err: %v, Content-Type: %q", err, ctype)
 		}
+		err = p.defect(opts, DefectMalformedMediaType, p.headerOffset(hContentType),
+			"malformed Content-Type %q", ctype)
+		if err != nil {
+			return err
+		}
 	}
 	p.ContentType = mtype
 	p.Boundary = tparams[hpBoundary]
@@ -61,10 +79,16 @@ func (p *Part) setupHeaders(r *bufio.Reader, defaultContentType string) error {
 	}
 
 	cdisp := header.Get(hContentDisposition)
-	disposition, dparams, err := mime.ParseMediaType(cdisp)
+	disposition, dparams, err := parseMediaType(cdisp)
 	if err == nil {
 		p.Disposition = disposition
 		p.FileName = decodeHeader(dparams[hpFileName])
+	} else if cdisp != "" {
+		err = p.defect(opts, DefectMalformedDisposition, p.headerOffset(hContentDisposition),
+			"malformed Content-Disposition %q: %v", cdisp, err)
+		if err != nil {
+			return err
+		}
 	}
 	if p.FileName == "" && tparams[hpName] != "" {
 		p.FileName = decodeHeader(tparams[hpName])
@@ -73,37 +97,157 @@ func (p *Part) setupHeaders(r *bufio.Reader, defaultContentType string) error {
 	return nil
 }
 
-func (p *Part) decodeContent(r io.Reader, encoding string) error {
-	if encoding == "" {
-		encoding = p.Header.Get(hContentEncoding)
+// headerOffset returns the message offset of the first header named key.
+func (p *Part) headerOffset(key string) int64 {
+	for i, k := range p.HeaderKeys {
+		if textproto.CanonicalMIMEHeaderKey(k) == key && i < len(p.headerOffsets) {
+			return p.headerOffsets[i]
+		}
 	}
-	contentReader := r
-	switch lowerTrim(encoding) {
+	return p.offset
+}
+
+// bodyReader decodes the Content-Transfer-Encoding of a leaf body,
+// decoding errors are recorded as defects.
+type bodyReader struct {
+	r        io.Reader
+	p        *Part
+	opts     *ParseOptions
+	cte      string
+	offset   int64 // message offset of the body
+	n        int64 // decoded bytes read
+	cleaner  *coding.Base64Cleaner
+	identity bool
+	err      error
+}
+
+func newBodyReader(p *Part, r io.Reader, encoding string, offset int64, opts *ParseOptions) (*bodyReader, error) {
+	cte := lowerTrim(encoding)
+	body := &bodyReader{r: r, p: p, opts: opts, cte: cte, offset: offset}
+	switch cte {
 	case cteQuotedPrintable:
-		contentReader = quotedprintable.NewReader(contentReader)
+		body.r = quotedprintable.NewReader(r)
 	case cteBase64:
-		b64cleaner := coding.NewBase64Cleaner(contentReader)
-		contentReader = base64.NewDecoder(base64.RawStdEncoding, b64cleaner)
+		body.cleaner = coding.NewBase64Cleaner(r)
+		body.r = base64.NewDecoder(base64.RawStdEncoding, body.cleaner)
 	case cte8Bit, cte7Bit, cteBinary, "":
 		// No decoding required.
+		body.identity = true
 	default:
 		// Unknown encoding.
+		err := p.defect(opts, DefectUnknownEncoding, p.headerOffset(hContentEncoding),
+			"unknown Content-Transfer-Encoding %q", encoding)
+		if err != nil {
+			return nil, err
+		}
 	}
+	return body, nil
+}
 
+func (b *bodyReader) Read(buf []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.r.Read(buf)
+	if b.identity && b.opts.Strict {
+		if i := invalidByte(buf[:n], b.cte); i >= 0 {
+			b.err = b.p.defect(b.opts, DefectInvalidEncoding, b.offset+b.n+int64(i),
+				"invalid byte 0x%02x in %q content", buf[i], b.cte)
+			return i, b.err
+		}
+	}
+	b.n += int64(n)
+	if err != nil {
+		b.err = b.finish(err)
+	}
+	return n, b.err
+}
+
+// finish records the defects of a body read up to err.
+func (b *bodyReader) finish(err error) error {
+	if err == io.EOF {
+		if b.cleaner != nil && b.cleaner.Invalid() > 0 {
+			derr := b.p.defect(b.opts, DefectInvalidBase64Chars, b.offset,
+				"%d invalid base64 characters stripped", b.cleaner.Invalid())
+			if derr != nil {
+				return derr
+			}
+		}
+		return err
+	}
+	if isLimitError(err) {
+		return err
+	}
+	if isDefectError(err) {
+		attachDefect(b.p, err)
+		return err
+	}
+	if derr := b.p.defect(b.opts, DefectCorruptContent, b.offset, "decode content: %v", err); derr != nil {
+		return derr
+	}
+	return err
+}
+
+func (p *Part) decodeContent(contentReader io.Reader) error {
 	// BUG: Bug in official "mime/quotedprintable" lib:
 	// quotedprintable reader may return `bufio.ErrBufferFull`
 	// before exhausting the reader.
 	content, err := ioutil.ReadAll(contentReader)
 	if err != nil {
+		if isLimitError(err) || isDefectError(err) {
+			return err
+		}
 		// If content is corrupt, keep the partial decoded content,
-		// silently ignore early errors and continue parsing.
+		// the error is recorded as a defect, continue parsing.
 		// return err
 	}
-	p.Content = content
+	if len(content) > 0 {
+		p.Content = content
+	}
 
 	return nil
 }
 
+func (p *Part) isText() bool {
+	return strings.HasPrefix(p.ContentType, "text/") && p.Charset != ""
+}
+
+// charsetReader returns a reader decoding text content from r to UTF-8.
+func (p *Part) charsetReader(r io.Reader, opts *ParseOptions) (io.Reader, error) {
+	if opts.RawCharset {
+		p.rawCharset = true
+		return r, nil
+	}
+	cr, err := coding.NewCharsetReader(p.Charset, r)
+	if err != nil {
+		p.rawCharset = true
+		return r, p.defect(opts, DefectUnknownCharset, p.headerOffset(hContentType), "%v", err)
+	}
+	return cr, nil
+}
+
+// decodeCharset decodes text content to UTF-8,
+// the original content is kept in RawContent.
+func (p *Part) decodeCharset(opts *ParseOptions) error {
+	if !p.isText() {
+		return nil
+	}
+	r, err := p.charsetReader(bytes.NewReader(p.Content), opts)
+	if err != nil || p.rawCharset {
+		return err
+	}
+	p.RawContent = p.Content
+	if coding.IsUTF8(p.Charset) {
+		return nil
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return p.defect(opts, DefectCorruptContent, p.offset, "decode charset %q: %v", p.Charset, err)
+	}
+	p.Content = content
+	return nil
+}
+
 // AddChild adds a child node into the part tree.
 func (p *Part) AddChild(child *Part) {
 	if child != nil {
@@ -112,98 +256,37 @@ func (p *Part) AddChild(child *Part) {
 	}
 }
 
-func parseMultiPart(parent *Part, r *bufio.Reader) error {
-	bdr := NewBoundaryReader(r, parent.Boundary)
-	for partIdx := 0; true; partIdx++ {
-		next, err := bdr.NextPart()
-		if err != nil && err != io.EOF {
-			return err
-		}
-		if !next {
-			break
-		}
+// Parse parses an email into `Part` tree.
+func Parse(r io.Reader) (*Part, error) {
+	return ParseWithOptions(r, nil)
+}
 
-		p := &Part{}
-		if parent.PartID == "" {
-			p.PartID = strconv.Itoa(partIdx)
-		} else {
-			p.PartID = parent.PartID + "." + strconv.Itoa(partIdx)
+// ParseWithOptions parses an email into `Part` tree with the given options.
+// A nil opts is the same as Parse. The original bytes of the message are
+// kept if KeepRaw is set, Encode writes unmodified parts verbatim.
+func ParseWithOptions(r io.Reader, opts *ParseOptions) (*Part, error) {
+	keepRaw := opts != nil && opts.KeepRaw
+	pr := newReader(r, opts, keepRaw)
+	// charset is decoded after reading to keep RawContent
+	pr.skipCharset = true
+	for {
+		p, body, err := pr.NextPart()
+		if err == io.EOF {
+			break
 		}
-		br := bufio.NewReader(bdr)
-		err = p.setupHeaders(br, defaultContentType)
 		if err != nil {
-			return err
-		}
-
-		if strings.HasPrefix(p.ContentType, ctMessagePrefix) {
-			if p.ContentType == ctRFC822 {
-				cte := lowerTrim(p.Header.Get(hContentEncoding))
-				// `message/rfc822` base64 attachment is treated as a new child part.
-				if cte == cteBase64 {
-					pp := &Part{
-						PartID:      p.PartID + ".0",
-						ContentType: ctTextPlain,
-					}
-					p.AddChild(pp)
-					if err := pp.decodeContent(br, cteBase64); err != nil {
-						return err
-					}
-				} else {
-					if part, err := parse(p, br); err == nil {
-						p.AddChild(part)
-					}
-				}
-			} else {
-				// TODO: handle other "message/xxx" types
-			}
-		}
-
-		parent.AddChild(p)
-
-		if p.Boundary == "" {
-			err = p.decodeContent(br, "")
-			if err != nil {
-				return err
-			}
-		} else {
-			err = parseMultiPart(p, br)
-			if err != nil {
-				return err
-			}
+			return nil, err
 		}
-	}
-	// burn off epilogues if there are any
-	_, _ = io.Copy(ioutil.Discard, r)
-
-	return nil
-}
-
-func parse(parent *Part, r io.Reader) (*Part, error) {
-	partID := ""
-	if parent != nil {
-		partID = parent.PartID + ".0"
-	}
-	root := &Part{PartID: partID}
-	br := bufio.NewReader(r)
-	err := root.setupHeaders(br, defaultContentType)
-	if err != nil {
-		return nil, err
-	}
-	if strings.HasPrefix(root.ContentType, ctMultipartPrefix) {
-		err = parseMultiPart(root, br)
-		if err != nil {
+		if err := p.decodeContent(body); err != nil {
 			return nil, err
 		}
-	} else {
-		err = root.decodeContent(br, "")
-		if err != nil {
+		if err := p.decodeCharset(pr.opts); err != nil {
 			return nil, err
 		}
 	}
+	root := pr.Root()
+	if keepRaw && root != nil {
+		root.setRaw(pr.raw.Bytes())
+	}
 	return root, nil
 }
-
-// Parse parses an email into `Part` tree.
-func Parse(r io.Reader) (*Part, error) {
-	return parse(nil, r)
-}
@@ -0,0 +1,212 @@
+package emime
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func nestedMultipart(depth int) string {
+	var sb strings.Builder
+	for i := 0; i < depth; i++ {
+		sb.WriteString("Content-Type: multipart/mixed; boundary=b" + string(rune('a'+i)) + "\r\n\r\n")
+		sb.WriteString("--b" + string(rune('a'+i)) + "\r\n")
+	}
+	sb.WriteString("Content-Type: text/plain\r\n\r\nleaf\r\n")
+	for i := depth - 1; i >= 0; i-- {
+		sb.WriteString("--b" + string(rune('a'+i)) + "--\r\n")
+	}
+	return sb.String()
+}
+
+func TestParseLimits(t *testing.T) {
+	tests := []struct {
+		limit string
+		input string
+		opts  ParseOptions
+	}{
+		{"MaxDepth", nestedMultipart(5), ParseOptions{MaxDepth: 3}},
+		{"MaxParts", streamInput, ParseOptions{MaxParts: 3}},
+		{"MaxHeaderBytes", "Subject: " + strings.Repeat("x", 100) + "\r\n\r\nbody", ParseOptions{MaxHeaderBytes: 64}},
+		{"MaxHeaderLines", strings.Repeat("X-A: a\r\n", 10) + "\r\nbody", ParseOptions{MaxHeaderLines: 5}},
+		{"MaxBodySize", "Subject: a\r\n\r\n" + strings.Repeat("x", 100), ParseOptions{MaxBodySize: 99}},
+		{"MaxMessageSize", streamInput, ParseOptions{MaxMessageSize: 100}},
+	}
+	for _, tt := range tests {
+		_, err := ParseWithOptions(strings.NewReader(tt.input), &tt.opts)
+		if !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("%s: got: %v, want: ErrLimitExceeded", tt.limit, err)
+		}
+		var lerr *LimitError
+		if !errors.As(err, &lerr) || lerr.Limit != tt.limit {
+			t.Fatalf("got: %v, want: %s", err, tt.limit)
+		}
+	}
+}
+
+func TestParseWithinLimits(t *testing.T) {
+	opts := &ParseOptions{
+		MaxDepth:       5,
+		MaxParts:       6,
+		MaxHeaderBytes: 128,
+		MaxHeaderLines: 4,
+		MaxBodySize:    11,
+		MaxMessageSize: 4096,
+	}
+	if _, err := ParseWithOptions(strings.NewReader(streamInput), opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseWithOptions(strings.NewReader(nestedMultipart(5)), opts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseDefects(t *testing.T) {
+	input := "Content-Type: multipart/mixed; boundary=b\r\n" +
+		": no name\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain; charset=utf-8; foo\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"a\x01b\r\n" +
+		"--b\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"aGVs!bG8=\r\n"
+	root, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		kind   DefectKind
+		partID string
+		offset int64
+	}{
+		{DefectHeaderMissingName, "", 43},
+		{DefectCloseBoundaryNotFound, "", int64(len(input))},
+		{DefectMalformedMediaType, "0", 61},
+		{DefectCorruptContent, "0", 154},
+		{DefectInvalidBase64Chars, "1", 201},
+	}
+	defects := root.AllDefects()
+	if len(defects) != len(want) {
+		t.Fatalf("got: %v, want %d defects", defects, len(want))
+	}
+	for i, w := range want {
+		d := defects[i]
+		if d.Kind != w.kind || d.PartID != w.partID || d.Offset != w.offset {
+			t.Errorf("got: %v, want: %s at offset %d, part %q", d, w.kind, w.offset, w.partID)
+		}
+	}
+	if string(root.Parts[1].Content) != "hello" {
+		t.Fatalf("got: %q, want: %q", root.Parts[1].Content, "hello")
+	}
+}
+
+func TestParseStrict(t *testing.T) {
+	tests := []struct {
+		input  string
+		kind   DefectKind
+		offset int64
+	}{
+		{"Subject: a\nTo: b\r\n\r\nbody", DefectBareLF, 10},
+		{"Subject: a\r\n\r\nbo\rdy", DefectBareCR, 16},
+		{"Subject: a\r\n\r\n" + strings.Repeat("x", 999) + "\r\n", DefectLineTooLong, 14},
+		{"Subject: caf\xc3\xa9\r\n\r\nbody", DefectNonASCIIHeader, 12},
+		{"Subject: a\r\n\r\nbody \xff\r\n", DefectInvalidEncoding, 19},
+		{"Content-Transfer-Encoding: x-uue\r\n\r\nbody", DefectUnknownEncoding, 0},
+		{"Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n\r\nbody\r\n", DefectCloseBoundaryNotFound, 58},
+		{"Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n\r\nbody\r\n--b--junk\r\n", DefectInvalidDelimiter, 58},
+	}
+	for _, tt := range tests {
+		_, err := ParseWithOptions(strings.NewReader(tt.input), &ParseOptions{Strict: true})
+		var derr *DefectError
+		if !errors.As(err, &derr) {
+			t.Fatalf("%s: got: %v, want: *DefectError", tt.kind, err)
+		}
+		if derr.Defect.Kind != tt.kind || derr.Defect.Offset != tt.offset {
+			t.Errorf("got: %v, want: %s at offset %d", derr.Defect, tt.kind, tt.offset)
+		}
+		// the same input parses in lenient mode
+		if _, err := Parse(strings.NewReader(tt.input)); err != nil {
+			t.Errorf("%s: lenient: %v", tt.kind, err)
+		}
+	}
+	opts := &ParseOptions{Strict: true, SMTPUTF8: true}
+	if _, err := ParseWithOptions(strings.NewReader(tests[3].input), opts); err != nil {
+		t.Fatalf("SMTPUTF8: %v", err)
+	}
+	if _, err := ParseWithOptions(strings.NewReader(streamInput), opts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseSample(t *testing.T) {
+	f, err := os.Open("cmd/dumpjson/sample.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	root, err := Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root.Parts) != 5 {
+		t.Fatalf("got: %d parts, want: %d", len(root.Parts), 5)
+	}
+	if len(root.Parts[2].Parts) != 2 || len(root.Parts[4].Parts) != 1 {
+		t.Fatal("unexpected part tree")
+	}
+}
+
+func TestParseCharset(t *testing.T) {
+	gbk := "\xd6\xd0\xce\xc4"
+	input := "Content-Type: text/plain; charset=gbk\r\n\r\n" + gbk
+	root, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(root.Content) != "中文" || string(root.RawContent) != gbk {
+		t.Fatalf("got: %q %q, want: %q %q", root.Content, root.RawContent, "中文", gbk)
+	}
+	buf := &bytes.Buffer{}
+	if err := root.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encoded.RawContent) != gbk {
+		t.Fatalf("got: %q, want: %q", encoded.RawContent, gbk)
+	}
+
+	root, err = ParseWithOptions(strings.NewReader(input), &ParseOptions{RawCharset: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(root.Content) != gbk {
+		t.Fatalf("got: %q, want: %q", root.Content, gbk)
+	}
+	buf.Reset()
+	if err := root.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if encoded, err = Parse(buf); err != nil || string(encoded.RawContent) != gbk {
+		t.Fatalf("got: %q, %v, want: %q", encoded.RawContent, err, gbk)
+	}
+
+	r := NewReader(strings.NewReader(input))
+	_, body, err := r.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := ioutil.ReadAll(body); string(content) != "中文" {
+		t.Fatalf("got: %q, want: %q", content, "中文")
+	}
+}
@@ -0,0 +1,93 @@
+package pgp
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/daogan/emime"
+	"github.com/pkg/errors"
+)
+
+// Inline block types.
+const (
+	InlineSignedMessage = "SIGNED MESSAGE"
+	InlineMessage       = "MESSAGE"
+	InlineSignature     = "SIGNATURE"
+	InlinePublicKey     = "PUBLIC KEY BLOCK"
+)
+
+// InlineBlock is an ASCII armored PGP block in a text body.
+type InlineBlock struct {
+	Type  string      // e.g. InlineSignedMessage.
+	Part  *emime.Part // The text part of the block.
+	Start int         // Offset of the block in the Part content.
+	Data  []byte      // The armored block.
+}
+
+// FindInline returns the inline PGP blocks of the text/plain parts of
+// root, in order.
+func FindInline(root *emime.Part) []*InlineBlock {
+	var blocks []*InlineBlock
+	if root.ContentType == "text/plain" || root.ContentType == "" && len(root.Parts) == 0 {
+		blocks = append(blocks, findBlocks(root)...)
+	}
+	for _, child := range root.Parts {
+		blocks = append(blocks, FindInline(child)...)
+	}
+	return blocks
+}
+
+func findBlocks(p *emime.Part) []*InlineBlock {
+	var blocks []*InlineBlock
+	content := p.Content
+	for offset := 0; offset < len(content); {
+		i := bytes.Index(content[offset:], []byte("-----BEGIN PGP "))
+		if i < 0 {
+			break
+		}
+		start := offset + i
+		line := content[start:]
+		if j := bytes.IndexByte(line, '\n'); j >= 0 {
+			line = line[:j]
+		}
+		typ := strings.TrimSuffix(strings.TrimSpace(string(line)), "-----")
+		typ = strings.TrimPrefix(typ, "-----BEGIN PGP ")
+		// a signed message ends with its signature
+		endType := typ
+		if typ == InlineSignedMessage {
+			endType = InlineSignature
+		}
+		end := bytes.Index(content[start:], []byte("-----END PGP "+endType+"-----"))
+		if end < 0 {
+			break
+		}
+		end = start + end + len("-----END PGP "+endType+"-----")
+		if start > 0 && content[start-1] != '\n' {
+			// not at the start of a line, e.g. quoted
+			offset = end
+			continue
+		}
+		blocks = append(blocks, &InlineBlock{Type: typ, Part: p, Start: start, Data: content[start:end]})
+		offset = end
+	}
+	return blocks
+}
+
+// VerifyInline verifies a clear signed inline block with the keys of
+// keyring, and returns the signer and the signed text.
+func VerifyInline(block *InlineBlock, keyring openpgp.KeyRing) (*openpgp.Entity, []byte, error) {
+	if block.Type != InlineSignedMessage {
+		return nil, nil, errors.Errorf("pgp: not a signed message: %q", block.Type)
+	}
+	b, _ := clearsign.Decode(block.Data)
+	if b == nil {
+		return nil, nil, errors.New("pgp: malformed signed message")
+	}
+	signer, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(b.Bytes), b.ArmoredSignature.Body, nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "pgp")
+	}
+	return signer, b.Plaintext, nil
+}
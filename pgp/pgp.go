@@ -0,0 +1,302 @@
+// Package pgp signs, verifies, encrypts and decrypts PGP/MIME messages,
+// RFC 3156, parsed by emime, and finds inline PGP blocks in text bodies.
+//
+//	root, _ := emime.ParseWithOptions(r, &emime.ParseOptions{KeepRaw: true})
+//	if pgp.IsEncrypted(root) {
+//		dec, err := pgp.Decrypt(root, keyring, nil)
+//		root = dec.Content
+//	}
+//	if pgp.IsSigned(root) {
+//		sig, err := pgp.Verify(root, keyring)
+//	}
+//
+// Signatures are verified on the original bytes of the signed part, the
+// message must be parsed with emime.ParseOptions.KeepRaw.
+package pgp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"mime"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/daogan/emime"
+	"github.com/pkg/errors"
+)
+
+const (
+	ctSigned       = "multipart/signed"
+	ctEncrypted    = "multipart/encrypted"
+	ctPGPSignature = "application/pgp-signature"
+	ctPGPEncrypted = "application/pgp-encrypted"
+	ctOctetStream  = "application/octet-stream"
+)
+
+// hash names of the micalg parameter, RFC 4880 9.4
+var micalgs = map[crypto.Hash]string{
+	crypto.SHA1:   "pgp-sha1",
+	crypto.SHA224: "pgp-sha224",
+	crypto.SHA256: "pgp-sha256",
+	crypto.SHA384: "pgp-sha384",
+	crypto.SHA512: "pgp-sha512",
+}
+
+// Signature is a verified PGP/MIME signature.
+type Signature struct {
+	Signer  *openpgp.Entity
+	Content *emime.Part // The signed part.
+}
+
+// Decrypted is the decrypted content of a PGP/MIME message.
+type Decrypted struct {
+	Content *emime.Part
+	// IsSigned is set if the content was signed and encrypted at once,
+	// RFC 3156 6.2, the signature is checked by Decrypt.
+	IsSigned bool
+	Signer   *openpgp.Entity // nil if the signer is not in the keyring.
+	// SignatureError is the error of the signature check, nil if valid.
+	SignatureError error
+}
+
+// IsSigned reports whether p is a PGP/MIME signed part.
+func IsSigned(p *emime.Part) bool {
+	return p.ContentType == ctSigned && protocol(p) == ctPGPSignature
+}
+
+// IsEncrypted reports whether p is a PGP/MIME encrypted part.
+func IsEncrypted(p *emime.Part) bool {
+	return p.ContentType == ctEncrypted && protocol(p) == ctPGPEncrypted
+}
+
+// Verify verifies the detached signature of a PGP/MIME signed part with
+// the keys of keyring.
+func Verify(p *emime.Part, keyring openpgp.KeyRing) (*Signature, error) {
+	if !IsSigned(p) {
+		return nil, errors.Errorf("pgp: not signed: %q", p.ContentType)
+	}
+	if len(p.Parts) != 2 {
+		return nil, errors.Errorf("pgp: %d parts in %s, want: 2", len(p.Parts), ctSigned)
+	}
+	content, sig := p.Parts[0], p.Parts[1]
+	if sig.ContentType != ctPGPSignature {
+		return nil, errors.Errorf("pgp: unexpected signature type %q", sig.ContentType)
+	}
+	raw := content.Raw()
+	if raw == nil {
+		return nil, errors.New("pgp: original bytes of the signed part not kept")
+	}
+	if !bytes.Contains(raw, []byte{'\r'}) {
+		// signed in canonical form, stored with LF line endings
+		raw = bytes.Replace(raw, []byte{'\n'}, []byte{'\r', '\n'}, -1)
+	}
+	signer, err := openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(raw), bytes.NewReader(sig.Content), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "pgp")
+	}
+	return &Signature{Signer: signer, Content: content}, nil
+}
+
+// Decrypt decrypts a PGP/MIME encrypted part with the keys of keyring,
+// and parses the decrypted content. prompt is called for keys protected
+// by a passphrase, see openpgp.ReadMessage.
+func Decrypt(p *emime.Part, keyring openpgp.KeyRing, prompt openpgp.PromptFunction) (*Decrypted, error) {
+	if !IsEncrypted(p) {
+		return nil, errors.Errorf("pgp: not encrypted: %q", p.ContentType)
+	}
+	if len(p.Parts) != 2 || p.Parts[0].ContentType != ctPGPEncrypted {
+		return nil, errors.Errorf("pgp: malformed %s", ctEncrypted)
+	}
+	block, err := armor.Decode(bytes.NewReader(p.Parts[1].Content))
+	if err != nil {
+		return nil, errors.Wrap(err, "pgp")
+	}
+	md, err := openpgp.ReadMessage(block.Body, keyring, prompt, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "pgp")
+	}
+	// the signature is checked once the body is read
+	data, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, errors.Wrap(err, "pgp")
+	}
+	// kept for the signature of a signed message inside
+	root, err := emime.ParseWithOptions(bytes.NewReader(data), &emime.ParseOptions{KeepRaw: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "pgp: decrypted content")
+	}
+	dec := &Decrypted{Content: root, IsSigned: md.IsSigned}
+	if md.IsSigned {
+		dec.SignatureError = md.SignatureError
+		if md.SignedBy != nil {
+			dec.Signer = md.SignedBy.Entity
+		} else if dec.SignatureError == nil {
+			dec.SignatureError = errors.Errorf("pgp: unknown signer %X", md.SignedByKeyId)
+		}
+	}
+	return dec, nil
+}
+
+// Sign returns the message p signed by signer as a PGP/MIME signed
+// message. The message headers of p are kept on the new root, the content
+// headers and body of p become the signed part.
+func Sign(p *emime.Part, signer *openpgp.Entity, config *packet.Config) (*emime.Part, error) {
+	header, content, err := splitEntity(p)
+	if err != nil {
+		return nil, err
+	}
+	sig := &bytes.Buffer{}
+	if err := openpgp.ArmoredDetachSign(sig, signer, bytes.NewReader(content), config); err != nil {
+		return nil, errors.Wrap(err, "pgp")
+	}
+	micalg, ok := micalgs[config.Hash()]
+	if !ok {
+		return nil, errors.Errorf("pgp: unsupported hash %v", config.Hash())
+	}
+	return multipart(header, "signed", map[string]string{"micalg": micalg, "protocol": ctPGPSignature},
+		content,
+		[]byte("Content-Type: application/pgp-signature; name=\"signature.asc\"\r\n"+
+			"Content-Description: OpenPGP digital signature\r\n"+
+			"Content-Disposition: attachment; filename=\"signature.asc\"\r\n"+
+			"\r\n"),
+		crlf(sig.Bytes()))
+}
+
+// Encrypt returns the message p encrypted to the keys of to as a PGP/MIME
+// encrypted message, signed at once if signer is not nil. The message
+// headers of p are kept on the new root in the clear.
+func Encrypt(p *emime.Part, to []*openpgp.Entity, signer *openpgp.Entity, config *packet.Config) (*emime.Part, error) {
+	header, content, err := splitEntity(p)
+	if err != nil {
+		return nil, err
+	}
+	enc := &bytes.Buffer{}
+	aw, err := armor.Encode(enc, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "pgp")
+	}
+	w, err := openpgp.Encrypt(aw, to, signer, nil, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "pgp")
+	}
+	if _, err := w.Write(content); err != nil {
+		return nil, errors.Wrap(err, "pgp")
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "pgp")
+	}
+	if err := aw.Close(); err != nil {
+		return nil, errors.Wrap(err, "pgp")
+	}
+	return multipart(header, "encrypted", map[string]string{"protocol": ctPGPEncrypted},
+		[]byte("Content-Type: application/pgp-encrypted\r\n"+
+			"Content-Description: PGP/MIME version identification\r\n"+
+			"\r\n"+
+			"Version: 1\r\n"),
+		[]byte("Content-Type: application/octet-stream; name=\"encrypted.asc\"\r\n"+
+			"Content-Description: OpenPGP encrypted message\r\n"+
+			"Content-Disposition: inline; filename=\"encrypted.asc\"\r\n"+
+			"\r\n"),
+		crlf(enc.Bytes()))
+}
+
+// splitEntity encodes p and splits it into the message header fields and
+// the MIME entity of the content headers and body.
+func splitEntity(p *emime.Part) (header, entity []byte, err error) {
+	buf := &bytes.Buffer{}
+	if err := p.Encode(buf); err != nil {
+		return nil, nil, errors.Wrap(err, "pgp")
+	}
+	msg := buf.Bytes()
+	end := bytes.Index(msg, []byte("\r\n\r\n"))
+	if end < 0 {
+		end = len(msg) - 2
+	}
+	var content []byte
+	for _, field := range splitFields(msg[:end+2]) {
+		name := strings.ToLower(field[:strings.IndexByte(field, ':')])
+		switch {
+		case strings.HasPrefix(name, "content-"):
+			content = append(content, field...)
+		case name == "mime-version":
+		default:
+			header = append(header, field...)
+		}
+	}
+	if content == nil {
+		content = []byte("Content-Type: text/plain; charset=us-ascii\r\n")
+	}
+	entity = append(content, '\r', '\n')
+	if end+4 <= len(msg) {
+		entity = append(entity, msg[end+4:]...)
+	}
+	return header, entity, nil
+}
+
+// splitFields splits header into fields, continuation lines included.
+func splitFields(header []byte) []string {
+	var fields []string
+	for _, line := range strings.SplitAfter(string(header), "\r\n") {
+		switch {
+		case line == "":
+		case line[0] == ' ' || line[0] == '\t':
+			if len(fields) > 0 {
+				fields[len(fields)-1] += line
+			}
+		case strings.IndexByte(line, ':') > 0:
+			fields = append(fields, line)
+		}
+	}
+	return fields
+}
+
+// multipart returns the parsed message of header and a multipart/subtype
+// of entities, the header and body of the last part split in two.
+func multipart(header []byte, subtype string, params map[string]string, first, lastHeader, lastBody []byte) (*emime.Part, error) {
+	boundary := newBoundary()
+	for bytes.Contains(first, []byte(boundary)) || bytes.Contains(lastBody, []byte(boundary)) {
+		boundary = newBoundary()
+	}
+	params["boundary"] = boundary
+	buf := bytes.NewBuffer(header)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: " + mime.FormatMediaType("multipart/"+subtype, params) + "\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString("--" + boundary + "\r\n")
+	buf.Write(first)
+	buf.WriteString("\r\n--" + boundary + "\r\n")
+	buf.Write(lastHeader)
+	buf.Write(lastBody)
+	buf.WriteString("\r\n--" + boundary + "--\r\n")
+	// a signed part must be encoded verbatim
+	root, err := emime.ParseWithOptions(buf, &emime.ParseOptions{KeepRaw: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "pgp")
+	}
+	return root, nil
+}
+
+func newBoundary() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// crlf makes the line breaks of an armored block CRLF.
+func crlf(b []byte) []byte {
+	b = bytes.Replace(b, []byte("\r\n"), []byte("\n"), -1)
+	return bytes.Replace(b, []byte("\n"), []byte("\r\n"), -1)
+}
+
+func protocol(p *emime.Part) string {
+	_, params, err := mime.ParseMediaType(p.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(params["protocol"])
+}
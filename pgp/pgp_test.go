@@ -0,0 +1,152 @@
+package pgp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/clearsign"
+	"github.com/daogan/emime"
+)
+
+const message = "From: alice@example.com\r\n" +
+	"To: bob@example.com\r\n" +
+	"Subject: secret\r\n" +
+	"Content-Type: multipart/mixed; boundary=b\r\n" +
+	"\r\n" +
+	"--b\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"hello bob\r\n" +
+	"--b--\r\n"
+
+func newEntity(t *testing.T, name string) *openpgp.Entity {
+	e, err := openpgp.NewEntity(name, "", name+"@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without preferences, RIPEMD-160 is the only candidate hash
+	for _, id := range e.Identities {
+		id.SelfSignature.PreferredHash = []uint8{8} // SHA-256
+	}
+	return e
+}
+
+// reparse encodes p and parses it again, as received.
+func reparse(t *testing.T, p *emime.Part) *emime.Part {
+	buf := &bytes.Buffer{}
+	if err := p.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	root, err := emime.ParseWithOptions(buf, &emime.ParseOptions{KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestSignVerify(t *testing.T) {
+	alice, eve := newEntity(t, "alice"), newEntity(t, "eve")
+	p, err := emime.ParseWithOptions(strings.NewReader(message), &emime.ParseOptions{KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := Sign(p, alice, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := reparse(t, signed)
+	if !IsSigned(root) || root.Header.Get("Subject") != "secret" {
+		t.Fatalf("got: %v", root.Header)
+	}
+	sig, err := Verify(root, openpgp.EntityList{alice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.Signer != alice || len(sig.Content.Parts) != 1 || string(sig.Content.Parts[0].Content) != "hello bob" {
+		t.Fatalf("got: %+v", sig)
+	}
+	if _, err := Verify(root, openpgp.EntityList{eve}); err == nil {
+		t.Fatal("want error for unknown signer")
+	}
+
+	buf := &bytes.Buffer{}
+	if err := root.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	forged, err := emime.ParseWithOptions(strings.NewReader(strings.Replace(buf.String(), "hello bob", "hello eve", 1)), &emime.ParseOptions{KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(forged, openpgp.EntityList{alice}); err == nil {
+		t.Fatal("want error for modified content")
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	alice, bob := newEntity(t, "alice"), newEntity(t, "bob")
+	p, err := emime.ParseWithOptions(strings.NewReader(message), &emime.ParseOptions{KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := Encrypt(p, []*openpgp.Entity{bob}, alice, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := reparse(t, encrypted)
+	if !IsEncrypted(root) || strings.Contains(string(root.Parts[1].Content), "hello bob") {
+		t.Fatalf("got: %q", root.Parts[1].Content)
+	}
+	dec, err := Decrypt(root, openpgp.EntityList{bob, alice}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dec.IsSigned || dec.SignatureError != nil || dec.Signer != alice {
+		t.Fatalf("got: %+v", dec)
+	}
+	if len(dec.Content.Parts) != 1 || string(dec.Content.Parts[0].Content) != "hello bob" {
+		t.Fatalf("got: %+v", dec.Content)
+	}
+	if _, err := Decrypt(root, openpgp.EntityList{alice}, nil); err == nil {
+		t.Fatal("want error for another recipient")
+	}
+}
+
+func TestFindInline(t *testing.T) {
+	alice := newEntity(t, "alice")
+	buf := &bytes.Buffer{}
+	w, err := clearsign.Encode(buf, alice.PrivateKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("signed inline\n"))
+	w.Close()
+	input := "Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"intro\n" + buf.String() + "\n" +
+		"> -----BEGIN PGP MESSAGE-----\n> quoted\n> -----END PGP MESSAGE-----\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"-----BEGIN PGP MESSAGE-----\n-----END PGP MESSAGE-----\r\n" +
+		"--b--\r\n"
+	root, err := emime.ParseWithOptions(strings.NewReader(input), &emime.ParseOptions{KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := FindInline(root)
+	if len(blocks) != 1 || blocks[0].Type != InlineSignedMessage || blocks[0].Start != len("intro\n") {
+		t.Fatalf("got: %+v", blocks)
+	}
+	signer, text, err := VerifyInline(blocks[0], openpgp.EntityList{alice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer != alice || string(text) != "signed inline\n" {
+		t.Fatalf("got: %q", text)
+	}
+}
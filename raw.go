@@ -0,0 +1,186 @@
+package emime
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"io"
+	"sort"
+)
+
+// rawPart is the original bytes of a parsed part, kept by Parse so that
+// Encode can write unmodified parts back byte for byte.
+type rawPart struct {
+	data      []byte   // the whole part, header and body
+	headerLen int      // length of the header in data, blank line included
+	gaps      [][]byte // bytes around children, gaps[i] precedes children[i]
+	children  []*Part  // Parts as parsed
+	sum       [sha256.Size]byte
+	// decoded is set when the children were parsed from the decoded body
+	// of an encoded message, they have no raw bytes and sum covers them.
+	decoded bool
+}
+
+// Raw returns the original bytes of the part, header and body, as they
+// were parsed. It returns nil for parts which were not parsed with
+// ParseOptions.KeepRaw, or were parsed from a transfer encoded
+// message/global.
+func (p *Part) Raw() []byte {
+	if p.raw == nil {
+		return nil
+	}
+	return p.raw.data
+}
+
+// setRaw keeps the original bytes of the part tree, data is the whole
+// message.
+func (p *Part) setRaw(data []byte) {
+	if p.isEncodedMessage() {
+		p.setDecodedRaw(data)
+		return
+	}
+	for _, child := range p.Parts {
+		child.setRaw(data)
+	}
+	if p.offset < 0 || p.headerEnd < p.offset || p.end < p.headerEnd || p.end > int64(len(data)) {
+		return
+	}
+	raw := &rawPart{
+		data:      data[p.offset:p.end],
+		headerLen: int(p.headerEnd - p.offset),
+		children:  append([]*Part(nil), p.Parts...),
+	}
+	prev := p.headerEnd
+	for _, child := range p.Parts {
+		if child.raw == nil || child.offset < prev {
+			return
+		}
+		raw.gaps = append(raw.gaps, data[prev:child.offset])
+		prev = child.end
+	}
+	if p.end < prev {
+		return
+	}
+	raw.gaps = append(raw.gaps, data[prev:p.end])
+	raw.sum = p.fingerprint()
+	p.raw = raw
+}
+
+// setDecodedRaw keeps the original bytes of an encoded message, its
+// children are only covered by the fingerprint.
+func (p *Part) setDecodedRaw(data []byte) {
+	if p.offset < 0 || p.headerEnd < p.offset || p.end < p.headerEnd || p.end > int64(len(data)) {
+		return
+	}
+	p.raw = &rawPart{
+		data:      data[p.offset:p.end],
+		headerLen: int(p.headerEnd - p.offset),
+		children:  append([]*Part(nil), p.Parts...),
+		sum:       p.treeFingerprint(),
+		decoded:   true,
+	}
+}
+
+// treeFingerprint hashes the fields of p and of its descendants.
+func (p *Part) treeFingerprint() (sum [sha256.Size]byte) {
+	h := sha256.New()
+	own := p.fingerprint()
+	h.Write(own[:])
+	for _, child := range p.Parts {
+		sum := child.treeFingerprint()
+		writeField(h, sum[:])
+	}
+	h.Sum(sum[:0])
+	return sum
+}
+
+// fingerprint hashes the fields of p which Encode depends on, but not
+// its children.
+func (p *Part) fingerprint() (sum [sha256.Size]byte) {
+	h := sha256.New()
+	for _, k := range p.HeaderKeys {
+		writeField(h, []byte(k))
+	}
+	keys := make([]string, 0, len(p.Header))
+	for k := range p.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeField(h, []byte(k))
+		for _, v := range p.Header[k] {
+			writeField(h, []byte(v))
+		}
+	}
+	writeField(h, []byte(p.ContentType))
+	writeField(h, []byte(p.Boundary))
+	writeField(h, []byte(p.Charset))
+	writeField(h, p.Content)
+	if p.rawCharset {
+		h.Write([]byte{1})
+	}
+	h.Sum(sum[:0])
+	return sum
+}
+
+// writeField writes b with its length, so that fields can not run into
+// each other.
+func writeField(h hash.Hash, b []byte) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(b)))
+	h.Write(n[:])
+	h.Write(b)
+}
+
+// unchanged reports whether the fields and the children of p are the
+// ones parsed.
+func (p *Part) unchanged() bool {
+	if p.raw == nil || len(p.Parts) != len(p.raw.children) {
+		return false
+	}
+	for i, child := range p.Parts {
+		if child != p.raw.children[i] {
+			return false
+		}
+	}
+	if p.raw.decoded {
+		return p.treeFingerprint() == p.raw.sum
+	}
+	return p.fingerprint() == p.raw.sum
+}
+
+// unmodified reports whether p and all of its descendants are unchanged.
+func (p *Part) unmodified() bool {
+	if !p.unchanged() {
+		return false
+	}
+	if p.raw.decoded {
+		return true
+	}
+	for _, child := range p.Parts {
+		if !child.unmodified() {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeRaw writes the original bytes of an unchanged part, only its
+// modified descendants are encoded again.
+func (p *Part) encodeRaw(writer io.Writer, opts *EncodeOptions) error {
+	if p.unmodified() {
+		_, err := writer.Write(p.raw.data)
+		return err
+	}
+	b := bufio.NewWriter(writer)
+	b.Write(p.raw.data[:p.raw.headerLen])
+	for i, child := range p.Parts {
+		b.Write(p.raw.gaps[i])
+		if err := child.EncodeWithOptions(b, opts); err != nil {
+			return err
+		}
+	}
+	b.Write(p.raw.gaps[len(p.Parts)])
+	return b.Flush()
+}
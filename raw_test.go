@@ -0,0 +1,107 @@
+package emime
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+var rawInput = "Received: from a.example\r\n" +
+	"\tby b.example; Mon, 2 Jan 2006 15:04:05 +0000\r\n" +
+	"Subject:   =?utf-8?q?caf=C3=A9?=   \r\n" +
+	"Content-Type: multipart/mixed; boundary=\"outer\"\r\n" +
+	"\r\n" +
+	"preamble\r\n" +
+	"--outer   \r\n" +
+	"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+	"Content-Transfer-Encoding: 8bit\r\n" +
+	"\r\n" +
+	"caf\xe9\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\n" +
+	"Content-Type: text/html\n" +
+	"\n" +
+	"<p>lf only</p>\n" +
+	"--inner--\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/octet-stream\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"AAEC\r\n" +
+	"AwQF\r\n" +
+	"--outer\r\n" +
+	"Content-Type: message/rfc822\r\n" +
+	"\r\n" +
+	"Subject: inner\r\n" +
+	"\r\n" +
+	"inner body\r\n" +
+	"--outer--\r\n" +
+	"epilogue\r\n"
+
+func TestEncodeRaw(t *testing.T) {
+	inputs := []string{rawInput, "Subject: no body", "Subject: x\n\nlf body\n"}
+	sample, err := ioutil.ReadFile("cmd/dumpjson/sample.eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs = append(inputs, string(sample))
+	for _, input := range inputs {
+		root, err := ParseWithOptions(strings.NewReader(input), &ParseOptions{KeepRaw: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := &bytes.Buffer{}
+		if err := root.Encode(buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != input {
+			t.Fatalf("got: %q, want: %q", buf.String(), input)
+		}
+		if string(root.Raw()) != input {
+			t.Fatalf("got: %q, want: %q", root.Raw(), input)
+		}
+	}
+}
+
+func TestEncodeRawModified(t *testing.T) {
+	root, err := ParseWithOptions(strings.NewReader(rawInput), &ParseOptions{KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(root.Parts[2].Raw()); !strings.HasSuffix(got, "\r\n\r\nAAEC\r\nAwQF") {
+		t.Fatalf("got: %q", got)
+	}
+	root.Parts[2].Content = []byte("new")
+	buf := &bytes.Buffer{}
+	if err := root.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	// only the modified part is encoded again
+	want := strings.Replace(rawInput, "AAEC\r\nAwQF", "bmV3\r\n", 1)
+	if buf.String() != want {
+		t.Fatalf("got: %q, want: %q", buf.String(), want)
+	}
+
+	root.Header.Set("Subject", "changed")
+	buf.Reset()
+	if err := root.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	// children are still written verbatim
+	inner := "--inner\nContent-Type: text/html\n\n<p>lf only</p>\n--inner--\n"
+	if !strings.Contains(buf.String(), "Subject: changed\r\n") || !strings.Contains(buf.String(), inner) {
+		t.Fatalf("got: %q", buf.String())
+	}
+
+	root, err = Parse(strings.NewReader(rawInput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Raw() != nil {
+		t.Fatal("raw bytes kept")
+	}
+}
@@ -0,0 +1,351 @@
+package emime
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Reader is a streaming parser. It walks the part tree depth-first and
+// hands back each part's headers along with a reader of its decoded body,
+// so that bodies of any size never have to be buffered in memory.
+type Reader struct {
+	src   *posReader
+	opts  *ParseOptions
+	root  *Part
+	parts int
+	stack []*frame
+	body  *posReader // raw body of the last leaf, drained on next call
+	leafp *Part      // part of body
+	err   error
+	raw   *bytes.Buffer // original bytes of the message, nil if not kept
+
+	skipCharset bool // do not decode text bodies to UTF-8
+}
+
+// frame is an open container part on the Reader stack.
+type frame struct {
+	part *Part
+	r    *posReader
+	bdr  *BoundaryReader // nil for `message/rfc822` containers
+	idx  int
+	done bool
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// posReader is a bufio.Reader which knows its offset in the message.
+type posReader struct {
+	*bufio.Reader
+	cr   *countingReader
+	base int64
+}
+
+func newPosReader(r io.Reader, base int64) *posReader {
+	cr := &countingReader{r: r}
+	return &posReader{Reader: bufio.NewReader(cr), cr: cr, base: base}
+}
+
+// offset returns the message offset of the next byte to be read.
+func (r *posReader) offset() int64 {
+	return r.base + r.cr.n - int64(r.Buffered())
+}
+
+// NewReader returns a new Reader reading an email from r.
+func NewReader(r io.Reader) *Reader {
+	return NewReaderWithOptions(r, nil)
+}
+
+// NewReaderWithOptions returns a new Reader with the given options.
+func NewReaderWithOptions(r io.Reader, opts *ParseOptions) *Reader {
+	return newReader(r, opts, false)
+}
+
+// newReader returns a new Reader, keepRaw keeps a copy of the message.
+func newReader(r io.Reader, opts *ParseOptions, keepRaw bool) *Reader {
+	if opts == nil {
+		opts = &ParseOptions{}
+	}
+	var raw *bytes.Buffer
+	if keepRaw {
+		raw = &bytes.Buffer{}
+		r = io.TeeReader(r, raw)
+	}
+	r = newLimitReader(r, opts.MaxMessageSize, "MaxMessageSize", nil)
+	if opts.Strict {
+		r = newLineChecker(r)
+	}
+	return &Reader{src: newPosReader(r, 0), opts: opts, raw: raw}
+}
+
+// Root returns the root part, or nil if NextPart has not been called yet.
+// The tree below it grows as parts are read; leaf Content is never set.
+func (r *Reader) Root() *Part {
+	return r.root
+}
+
+// NextPart returns the next part in depth-first order and a reader of its
+// decoded body, text bodies are decoded to UTF-8 unless RawCharset is
+// set. Container parts (multipart/*, message/rfc822, message/global and
+// message/global-headers) are returned with an empty body before their
+// children. The body is only valid until the next call to NextPart;
+// unread data is skipped. io.EOF is returned after the last part.
+func (r *Reader) NextPart() (*Part, io.Reader, error) {
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+	for {
+		p, body, err := r.next()
+		if err == nil {
+			return p, body, nil
+		}
+		if err == io.EOF || isLimitError(err) || isDefectError(err) || !r.recover(err) {
+			r.err = err
+			return nil, nil, err
+		}
+	}
+}
+
+func (r *Reader) next() (*Part, io.Reader, error) {
+	if r.body != nil {
+		if _, err := io.Copy(ioutil.Discard, r.body); err != nil {
+			return nil, nil, err
+		}
+		r.leafp.end = r.body.offset()
+		r.body = nil
+	}
+	if r.root == nil {
+		r.root = &Part{}
+		if err := r.newPart(r.root, r.src); err != nil {
+			return nil, nil, err
+		}
+		isMultipart := strings.HasPrefix(r.root.ContentType, ctMultipartPrefix)
+		return r.enter(r.root, r.src, isMultipart)
+	}
+	for len(r.stack) > 0 {
+		f := r.stack[len(r.stack)-1]
+		if f.bdr == nil {
+			if f.done {
+				if err := r.pop(); err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			f.done = true
+			return r.nextMessage(f)
+		}
+
+		next, err := f.bdr.NextPart()
+		if err != nil && err != io.EOF {
+			return nil, nil, r.delimiterError(f, err)
+		}
+		if !next {
+			if err := r.checkEnd(f); err != nil {
+				return nil, nil, err
+			}
+			if err := r.pop(); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		p := &Part{PartID: childPartID(f.part, f.idx)}
+		f.idx++
+		br := newPosReader(f.bdr, f.r.offset())
+		f.part.AddChild(p)
+		if err := r.newPart(p, br); err != nil {
+			return nil, nil, err
+		}
+		return r.enter(p, br, p.Boundary != "")
+	}
+	return nil, nil, io.EOF
+}
+
+// nextMessage returns the single encapsulated part of a `message/rfc822`,
+// `message/global` or `message/global-headers`.
+func (r *Reader) nextMessage(f *frame) (*Part, io.Reader, error) {
+	p := &Part{PartID: f.part.PartID + ".0"}
+	if f.part.isEncodedMessage() {
+		body, err := newBodyReader(f.part, f.r, f.part.Header.Get(hContentEncoding), f.r.offset(), r.opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		// offsets in the decoded body are not message offsets
+		br := newPosReader(body, f.r.offset())
+		f.part.AddChild(p)
+		if err := r.newPart(p, br); err != nil {
+			return nil, nil, err
+		}
+		return r.enter(p, br, strings.HasPrefix(p.ContentType, ctMultipartPrefix))
+	}
+	// `message/rfc822` base64 attachment is treated as a new child part.
+	if lowerTrim(f.part.Header.Get(hContentEncoding)) == cteBase64 {
+		if err := r.newPart(p, nil); err != nil {
+			return nil, nil, err
+		}
+		p.ContentType = ctTextPlain
+		p.offset = f.r.offset()
+		p.headerEnd = p.offset
+		f.part.AddChild(p)
+		r.body, r.leafp = f.r, p
+		return r.leaf(p, f.r, cteBase64)
+	}
+	f.part.AddChild(p)
+	if err := r.newPart(p, f.r); err != nil {
+		return nil, nil, err
+	}
+	isMultipart := strings.HasPrefix(p.ContentType, ctMultipartPrefix)
+	return r.enter(p, f.r, isMultipart)
+}
+
+// enter returns p, pushing a new frame if p is a container.
+func (r *Reader) enter(p *Part, br *posReader, isMultipart bool) (*Part, io.Reader, error) {
+	isMessage := p.Parent != nil && p.isMessage()
+	if isMultipart || isMessage {
+		if err := r.checkContainer(p); err != nil {
+			return nil, nil, err
+		}
+	}
+	if isMultipart {
+		bdr := NewBoundaryReader(br.Reader, p.Boundary)
+		bdr.strict = r.opts.Strict
+		r.stack = append(r.stack, &frame{part: p, r: br, bdr: bdr})
+		return p, strings.NewReader(""), nil
+	}
+	if isMessage {
+		r.stack = append(r.stack, &frame{part: p, r: br})
+		return p, strings.NewReader(""), nil
+	}
+	r.body, r.leafp = br, p
+	return r.leaf(p, br, p.Header.Get(hContentEncoding))
+}
+
+// leaf returns p with the decoded body reader.
+func (r *Reader) leaf(p *Part, br *posReader, encoding string) (*Part, io.Reader, error) {
+	body, err := newBodyReader(p, br, encoding, br.offset(), r.opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	content := io.Reader(body)
+	if p.isText() && !r.skipCharset {
+		if content, err = p.charsetReader(body, r.opts); err != nil {
+			return nil, nil, err
+		}
+	}
+	return p, newLimitReader(content, r.opts.MaxBodySize, "MaxBodySize", p), nil
+}
+
+// checkContainer checks the boundary and encoding of a container part.
+func (r *Reader) checkContainer(p *Part) error {
+	if !p.isMessage() && p.Boundary == "" {
+		if err := p.defect(r.opts, DefectMissingBoundary, p.offset, "multipart without boundary"); err != nil {
+			return err
+		}
+	}
+	// rfc2045: composite types must not be encoded, strict mode only,
+	// rfc6532 allows message/global to be
+	cte := lowerTrim(p.Header.Get(hContentEncoding))
+	identity := cte == "" || cte == cte7Bit || cte == cte8Bit || cte == cteBinary
+	if r.opts.Strict && !identity && p.ContentType != ctGlobal {
+		return p.defect(r.opts, DefectInvalidEncoding, p.headerOffset(hContentEncoding),
+			"Content-Transfer-Encoding %q on %s", cte, p.ContentType)
+	}
+	return nil
+}
+
+// checkEnd checks how the multipart of f ended.
+func (r *Reader) checkEnd(f *frame) error {
+	if f.bdr.partsRead == 0 {
+		return f.part.defect(r.opts, DefectStartBoundaryNotFound, f.r.offset(),
+			"no part found with boundary %q", f.part.Boundary)
+	}
+	if !f.bdr.finished {
+		return f.part.defect(r.opts, DefectCloseBoundaryNotFound, f.r.offset(),
+			"close delimiter of boundary %q not found", f.part.Boundary)
+	}
+	return nil
+}
+
+// delimiterError records errors of boundary delimiter lines as defects.
+func (r *Reader) delimiterError(f *frame, err error) error {
+	var lerr *unexpectedLineError
+	if r.opts.Strict && errors.As(err, &lerr) {
+		offset := f.r.offset() - int64(len(lerr.line))
+		return f.part.defect(r.opts, DefectInvalidDelimiter, offset, "unexpected line %q", lerr.line)
+	}
+	attachDefect(f.part, err)
+	return err
+}
+
+// pop closes the innermost container, burning off any epilogue.
+func (r *Reader) pop() error {
+	f := r.stack[len(r.stack)-1]
+	r.stack = r.stack[:len(r.stack)-1]
+	_, err := io.Copy(ioutil.Discard, f.r)
+	f.part.end = f.r.offset()
+	if isLimitError(err) || isDefectError(err) {
+		attachDefect(f.part, err)
+		return err
+	}
+	return nil
+}
+
+// recover drops the innermost `message/rfc822` sub tree after a parse error
+// inside of it, so that one malformed attachment does not fail the message.
+func (r *Reader) recover(err error) bool {
+	for i := len(r.stack) - 1; i >= 0; i-- {
+		f := r.stack[i]
+		if f.bdr == nil {
+			f.part.addDefect(DefectMalformedMessage, f.r.offset(),
+				"encapsulated message dropped: %v", err)
+			f.done = true
+			f.part.Parts = nil
+			r.stack = r.stack[:i+1]
+			r.body = nil
+			return true
+		}
+	}
+	return false
+}
+
+// newPart checks the limits and reads the headers of p from br.
+func (r *Reader) newPart(p *Part, br *posReader) (err error) {
+	defer func() { attachDefect(p, err) }()
+	r.parts++
+	if max := r.opts.MaxParts; max > 0 && r.parts > max {
+		return &LimitError{Limit: "MaxParts", Max: int64(max), PartID: p.PartID}
+	}
+	if max := r.opts.MaxDepth; max > 0 && len(r.stack) > max {
+		return &LimitError{Limit: "MaxDepth", Max: int64(max), PartID: p.PartID}
+	}
+	if br == nil {
+		return nil
+	}
+	p.offset = br.offset()
+	if err := p.setupHeaders(br, defaultContentType, r.opts); err != nil {
+		return err
+	}
+	p.headerEnd = br.offset()
+	return nil
+}
+
+func childPartID(parent *Part, idx int) string {
+	if parent.PartID == "" {
+		return strconv.Itoa(idx)
+	}
+	return parent.PartID + "." + strconv.Itoa(idx)
+}
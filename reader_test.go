@@ -0,0 +1,75 @@
+package emime
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+var streamInput = "Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"preamble\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"hello\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/octet-stream\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"aGVsbG8g\r\nd29ybGQ=\r\n" +
+	"--outer\r\n" +
+	"Content-Type: message/rfc822\r\n" +
+	"\r\n" +
+	"Subject: inner\r\n" +
+	"\r\n" +
+	"inner body\r\n" +
+	"--outer--\r\n" +
+	"epilogue\r\n"
+
+func TestReaderNextPart(t *testing.T) {
+	want := []struct {
+		partID string
+		ctype  string
+		body   string
+	}{
+		{"", "multipart/mixed", ""},
+		{"0", "text/plain", "hello"},
+		{"1", "application/octet-stream", "hello world"},
+		{"2", "message/rfc822", ""},
+		{"2.0", "text/plain", "inner body"},
+	}
+	r := NewReader(strings.NewReader(streamInput))
+	for i, w := range want {
+		p, body, err := r.NextPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		content, _ := ioutil.ReadAll(body)
+		if p.PartID != w.partID || p.ContentType != w.ctype || string(content) != w.body {
+			t.Fatalf("got: %q %q %q, want: %q %q %q",
+				p.PartID, p.ContentType, content, w.partID, w.ctype, w.body)
+		}
+	}
+	if _, _, err := r.NextPart(); err == nil {
+		t.Fatal("expect io.EOF")
+	}
+	if len(r.Root().Parts) != 3 || len(r.Root().Parts[2].Parts) != 1 {
+		t.Fatal("unexpected part tree")
+	}
+}
+
+func TestReaderSkipBody(t *testing.T) {
+	r := NewReader(strings.NewReader(streamInput))
+	var ids []string
+	for {
+		p, _, err := r.NextPart()
+		if err != nil {
+			break
+		}
+		ids = append(ids, p.PartID)
+	}
+	if got := strings.Join(ids, ","); got != ",0,1,2,2.0" {
+		t.Fatalf("got: %s, want: %s", got, ",0,1,2,2.0")
+	}
+}
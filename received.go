@@ -0,0 +1,228 @@
+package emime
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// Received is a parsed Received header, one hop of the message.
+type Received struct {
+	FromHost string    // Name the client gave in HELO/EHLO.
+	FromRDNS string    // Reverse DNS name of the client, if recorded.
+	FromIP   string    // IP address of the client.
+	ByHost   string    // Receiving host.
+	With     string    // Protocol, e.g. ESMTPS or LMTP.
+	TLS      string    // TLS version and cipher, if recorded.
+	ID       string    // Queue id of the receiving host.
+	For      string    // Recipient address.
+	Date     time.Time // Zero if the timestamp is missing or unusable.
+	Raw      string    // Unfolded header value.
+}
+
+// ReceivedChain returns the Received headers of p in hop order, the hop
+// closest to the sender first.
+func (p *Part) ReceivedChain() []*Received {
+	values := p.Header["Received"]
+	chain := make([]*Received, 0, len(values))
+	// every hop prepends its header
+	for i := len(values) - 1; i >= 0; i-- {
+		chain = append(chain, ParseReceived(values[i]))
+	}
+	return chain
+}
+
+// ParseReceived parses the value of a Received header. It never fails,
+// the fields which could not be found are left empty. The formats of
+// Postfix, Exim, Exchange, Gmail, qmail and Sendmail are understood.
+func ParseReceived(value string) *Received {
+	r := &Received{Raw: strings.Join(strings.Fields(unfold(value)), " ")}
+	text := r.Raw
+	if i := strings.LastIndexByte(text, ';'); i >= 0 {
+		if t, err := ParseDate(text[i+1:]); err == nil {
+			r.Date = t
+		}
+		text = text[:i]
+	}
+
+	// clause name -> words and comments
+	clauses := make(map[string]*receivedClause)
+	clause := &receivedClause{}
+	for _, tok := range tokenizeReceived(text) {
+		name := strings.ToLower(tok)
+		_, known := clauses[name]
+		switch {
+		case !known && isReceivedKeyword(name):
+			clause = &receivedClause{}
+			clauses[name] = clause
+		case name == "tls" && clauses["with"] == clause:
+			// Exim: `with esmtps tls TLS_AES_256_GCM_SHA384`
+			clause = &receivedClause{}
+			clauses[name] = clause
+		case tok[0] == '(':
+			clause.comments = append(clause.comments, strings.TrimSpace(tok[1:len(tok)-1]))
+		default:
+			clause.words = append(clause.words, tok)
+		}
+	}
+
+	if c := clauses["from"]; c != nil {
+		r.parseFrom(c)
+	}
+	if c := clauses["by"]; c != nil {
+		r.ByHost = c.word(0)
+		r.parseTLS(c.comments)
+	}
+	if c := clauses["with"]; c != nil {
+		r.With = strings.Join(c.words, " ")
+		r.parseTLS(c.comments)
+	}
+	if c := clauses["tls"]; c != nil {
+		r.TLS = strings.TrimSpace(r.TLS + " " + strings.Join(c.words, " "))
+	}
+	if c := clauses["id"]; c != nil {
+		r.ID = c.word(0)
+		r.parseTLS(c.comments)
+	}
+	if c := clauses["for"]; c != nil {
+		r.For = strings.Trim(c.word(0), "<>")
+		r.parseTLS(c.comments)
+	}
+	return r
+}
+
+// receivedClause is the words and comments following a keyword.
+type receivedClause struct {
+	words    []string
+	comments []string
+}
+
+func (c *receivedClause) word(i int) string {
+	if i < len(c.words) {
+		return c.words[i]
+	}
+	return ""
+}
+
+func isReceivedKeyword(s string) bool {
+	switch s {
+	case "from", "by", "via", "with", "id", "for":
+		return true
+	}
+	return false
+}
+
+// parseFrom handles the forms:
+// Postfix:  from HELO (RDNS [IP])
+// Exim:     from RDNS ([IP] helo=HELO), from [IP] (helo=HELO)
+// Exchange: from HELO (IP)
+// qmail:    from RDNS (HELO HELO) (IP)
+func (r *Received) parseFrom(c *receivedClause) {
+	first := c.word(0)
+	if ip := parseIP(first); ip != "" {
+		r.FromIP = ip
+	} else {
+		r.FromHost = first
+	}
+	helo := ""
+	for _, comment := range c.comments {
+		if r.parseTLS([]string{comment}) {
+			continue
+		}
+		fields := strings.Fields(comment)
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			lower := strings.ToLower(field)
+			switch {
+			case strings.HasPrefix(lower, "helo="):
+				helo = field[len("helo="):]
+			case lower == "helo" || lower == "ehlo":
+				if i+1 < len(fields) {
+					i++
+					helo = fields[i]
+				}
+			case parseIP(field) != "":
+				if r.FromIP == "" {
+					r.FromIP = parseIP(field)
+				}
+			case r.FromRDNS == "" && strings.Contains(field, ".") && i == 0:
+				r.FromRDNS = strings.TrimSuffix(field, ".")
+			}
+		}
+	}
+	if helo != "" {
+		if r.FromRDNS == "" && r.FromHost != "" && !strings.EqualFold(r.FromHost, "unknown") {
+			r.FromRDNS = r.FromHost
+		}
+		r.FromHost = helo
+	}
+}
+
+// parseTLS records the first of comments describing TLS, it reports
+// whether there was one.
+func (r *Received) parseTLS(comments []string) bool {
+	for _, comment := range comments {
+		if !isTLSComment(comment) {
+			continue
+		}
+		if r.TLS == "" {
+			r.TLS = comment
+		}
+		return true
+	}
+	return false
+}
+
+// isTLSComment reports whether comment describes TLS, e.g. `TLS1.2`,
+// `using TLSv1.3 with cipher ...`, `version=TLS1_2, cipher=...` or
+// `Google Transport Security`.
+func isTLSComment(comment string) bool {
+	lower := strings.ToLower(comment)
+	for _, s := range []string{"cipher", "tls1", "tlsv1", "tls 1", "transport security"} {
+		if strings.Contains(lower, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIP returns the IP address of an address literal like `[1.2.3.4]`,
+// `[IPv6:::1]` or `1.2.3.4`, or "" if s is none.
+func parseIP(s string) string {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	if len(s) > 5 && strings.EqualFold(s[:5], "ipv6:") {
+		s = s[5:]
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// tokenizeReceived splits s into words and comments, comments keep
+// their parentheses.
+func tokenizeReceived(s string) []string {
+	var toks []string
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(':
+			j := skipComment(s, i)
+			tok := s[i:j]
+			if !strings.HasSuffix(tok, ")") {
+				tok += ")"
+			}
+			toks = append(toks, tok)
+			i = j
+		default:
+			j := strings.IndexAny(s[i:], " \t(")
+			if j < 0 {
+				j = len(s) - i
+			}
+			toks = append(toks, s[i:i+j])
+			i += j
+		}
+	}
+	return toks
+}
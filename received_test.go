@@ -0,0 +1,96 @@
+package emime
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseReceived(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Received
+	}{
+		// Postfix
+		{"from mail.example.com (mail.example.com [192.0.2.1])\r\n" +
+			"\t(using TLSv1.3 with cipher TLS_AES_256_GCM_SHA384 (256/256 bits))\r\n" +
+			"\t(No client certificate requested)\r\n" +
+			"\tby mx.example.net (Postfix) with ESMTPS id 4ABC123\r\n" +
+			"\tfor <user@example.net>; Mon, 2 Jan 2006 15:04:05 +0000 (UTC)",
+			Received{FromHost: "mail.example.com", FromRDNS: "mail.example.com", FromIP: "192.0.2.1",
+				ByHost: "mx.example.net", With: "ESMTPS", ID: "4ABC123", For: "user@example.net",
+				TLS: "using TLSv1.3 with cipher TLS_AES_256_GCM_SHA384 (256/256 bits)"}},
+		// Exim
+		{"from host.example.org ([198.51.100.7] helo=HELO.example.org)\r\n" +
+			"\tby mx.example.net with esmtps (TLS1.3) tls TLS_AES_256_GCM_SHA384\r\n" +
+			"\t(Exim 4.94) (envelope-from <a@example.org>) id 1abcD-000123-AB\r\n" +
+			"\tfor user@example.net; Mon, 02 Jan 2006 15:04:05 +0100",
+			Received{FromHost: "HELO.example.org", FromRDNS: "host.example.org", FromIP: "198.51.100.7",
+				ByHost: "mx.example.net", With: "esmtps", ID: "1abcD-000123-AB", For: "user@example.net",
+				TLS: "TLS1.3 TLS_AES_256_GCM_SHA384"}},
+		// Exchange
+		{"from EX1.corp.local (10.0.0.1) by EX2.corp.local (10.0.0.2) with Microsoft SMTP\r\n" +
+			" Server (version=TLS1_2, cipher=TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384) id\r\n" +
+			" 15.1.2375.31 via Frontend Transport; Mon, 2 Jan 2006 15:04:05 +0000",
+			Received{FromHost: "EX1.corp.local", FromIP: "10.0.0.1", ByHost: "EX2.corp.local",
+				With: "Microsoft SMTP Server", ID: "15.1.2375.31",
+				TLS: "version=TLS1_2, cipher=TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}},
+		// Gmail
+		{"from mail-sor-f41.google.com (mail-sor-f41.google.com. [209.85.220.41])\r\n" +
+			"        by mx.google.com with SMTPS id a1sor123.2006.01.02.15.04.05\r\n" +
+			"        for <user@gmail.com>\r\n" +
+			"        (Google Transport Security);\r\n" +
+			"        Mon, 02 Jan 2006 15:04:05 -0800 (PST)",
+			Received{FromHost: "mail-sor-f41.google.com", FromRDNS: "mail-sor-f41.google.com", FromIP: "209.85.220.41",
+				ByHost: "mx.google.com", With: "SMTPS", ID: "a1sor123.2006.01.02.15.04.05", For: "user@gmail.com",
+				TLS: "Google Transport Security"}},
+		{"by 2002:a05:6a10:a0d1:0:0:0:0 with SMTP id x1csp123; Mon, 2 Jan 2006 15:04:05 -0800 (PST)",
+			Received{ByHost: "2002:a05:6a10:a0d1:0:0:0:0", With: "SMTP", ID: "x1csp123"}},
+		// qmail
+		{"from unknown (HELO client.example) (203.0.113.9)\r\n" +
+			"  by mail.example.net with SMTP; 2 Jan 2006 15:04:05 -0000",
+			Received{FromHost: "client.example", FromIP: "203.0.113.9", ByHost: "mail.example.net", With: "SMTP"}},
+		{"(qmail 12345 invoked by uid 89); 2 Jan 2006 15:04:05 -0000", Received{}},
+		// LMTP with an IPv6 literal
+		{"from mx.example.net (mx.example.net [IPv6:2001:db8::1])\r\n" +
+			"\tby imap.example.net with LMTP id 7xYz; Mon, 2 Jan 2006 15:04:05 +0000",
+			Received{FromHost: "mx.example.net", FromRDNS: "mx.example.net", FromIP: "2001:db8::1",
+				ByHost: "imap.example.net", With: "LMTP", ID: "7xYz"}},
+	}
+	for _, tt := range tests {
+		got := ParseReceived(tt.input)
+		if got.Date.IsZero() {
+			t.Fatalf("%q: no date", tt.input)
+		}
+		tt.want.Date, tt.want.Raw = got.Date, got.Raw
+		if *got != tt.want {
+			t.Fatalf("got: %+v, want: %+v", *got, tt.want)
+		}
+	}
+}
+
+func TestReceivedChain(t *testing.T) {
+	input := "Received: from b.example by c.example; Mon, 2 Jan 2006 15:04:10 +0000\r\n" +
+		"Received: from a.example by b.example; Mon, 2 Jan 2006 15:04:05 +0000\r\n" +
+		"Received: by a.example; garbage\r\n" +
+		"\r\n" +
+		"body"
+	root, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := root.ReceivedChain()
+	var hosts []string
+	for _, r := range chain {
+		hosts = append(hosts, r.ByHost)
+	}
+	if got := strings.Join(hosts, ","); got != "a.example,b.example,c.example" {
+		t.Fatalf("got: %s, want: %s", got, "a.example,b.example,c.example")
+	}
+	if !chain[0].Date.IsZero() {
+		t.Fatalf("got: %s, want zero date", chain[0].Date)
+	}
+	if want := time.Date(2006, 1, 2, 15, 4, 10, 0, time.UTC); !chain[2].Date.Equal(want) {
+		t.Fatalf("got: %s, want: %s", chain[2].Date, want)
+	}
+}
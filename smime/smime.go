@@ -0,0 +1,188 @@
+// Package smime verifies and decrypts S/MIME messages, RFC 8551, parsed
+// by emime.
+//
+//	root, _ := emime.ParseWithOptions(r, &emime.ParseOptions{KeepRaw: true})
+//	if smime.IsEncrypted(root) {
+//		root, err = smime.Decrypt(root, cert, key)
+//	}
+//	if smime.IsSigned(root) {
+//		sig, err := smime.Verify(root, roots)
+//	}
+//
+// Detached signatures are verified on the original bytes of the signed
+// part, the message must be parsed with emime.ParseOptions.KeepRaw.
+package smime
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"mime"
+	"strings"
+
+	"github.com/daogan/emime"
+	"github.com/pkg/errors"
+	"go.mozilla.org/pkcs7"
+)
+
+const (
+	ctSigned         = "multipart/signed"
+	ctPKCS7Mime      = "application/pkcs7-mime"
+	ctPKCS7Signature = "application/pkcs7-signature"
+	// legacy types of old clients
+	ctXPKCS7Mime      = "application/x-pkcs7-mime"
+	ctXPKCS7Signature = "application/x-pkcs7-signature"
+
+	smimeSignedData    = "signed-data"
+	smimeEnvelopedData = "enveloped-data"
+)
+
+// Signature is a verified S/MIME signature.
+type Signature struct {
+	// Signers are the certificates of the signers.
+	Signers []*x509.Certificate
+	// Certificates are all certificates included in the signature.
+	Certificates []*x509.Certificate
+	// Content is the signed content.
+	Content *emime.Part
+}
+
+// IsSigned reports whether p is a detached or opaque S/MIME signature.
+func IsSigned(p *emime.Part) bool {
+	if p.ContentType == ctSigned {
+		return isSignature(protocol(p))
+	}
+	return isPKCS7Mime(p.ContentType) && smimeType(p) != smimeEnvelopedData
+}
+
+// IsEncrypted reports whether p is S/MIME enveloped data.
+func IsEncrypted(p *emime.Part) bool {
+	return isPKCS7Mime(p.ContentType) && smimeType(p) == smimeEnvelopedData
+}
+
+// Verify verifies the signature of p, a `multipart/signed` part with a
+// detached signature, or an `application/pkcs7-mime` part with signed-data.
+// The signers' certificates must chain to roots.
+func Verify(p *emime.Part, roots *x509.CertPool) (*Signature, error) {
+	if roots == nil {
+		return nil, errors.New("smime: no roots")
+	}
+	if p.ContentType == ctSigned {
+		return verifyDetached(p, roots)
+	}
+	if !isPKCS7Mime(p.ContentType) {
+		return nil, errors.Errorf("smime: not signed: %q", p.ContentType)
+	}
+	p7, err := pkcs7.Parse(p.Content)
+	if err != nil {
+		return nil, errors.Wrap(err, "smime")
+	}
+	if err := p7.VerifyWithChain(roots); err != nil {
+		return nil, errors.Wrap(err, "smime")
+	}
+	content, err := emime.ParseWithOptions(bytes.NewReader(p7.Content), &emime.ParseOptions{KeepRaw: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "smime: signed content")
+	}
+	return newSignature(p7, content), nil
+}
+
+func verifyDetached(p *emime.Part, roots *x509.CertPool) (*Signature, error) {
+	if len(p.Parts) != 2 {
+		return nil, errors.Errorf("smime: %d parts in %s, want: 2", len(p.Parts), ctSigned)
+	}
+	content, sig := p.Parts[0], p.Parts[1]
+	if !isSignature(sig.ContentType) {
+		return nil, errors.Errorf("smime: unexpected signature type %q", sig.ContentType)
+	}
+	raw := content.Raw()
+	if raw == nil {
+		return nil, errors.New("smime: original bytes of the signed part not kept")
+	}
+	p7, err := pkcs7.Parse(sig.Content)
+	if err != nil {
+		return nil, errors.Wrap(err, "smime")
+	}
+	p7.Content = raw
+	err = p7.VerifyWithChain(roots)
+	if err != nil && bytes.Contains(raw, []byte{'\n'}) && !bytes.Contains(raw, []byte{'\r'}) {
+		// signed in canonical form, stored with LF line endings
+		p7.Content = bytes.Replace(raw, []byte{'\n'}, []byte{'\r', '\n'}, -1)
+		if p7.VerifyWithChain(roots) == nil {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "smime")
+	}
+	return newSignature(p7, content), nil
+}
+
+func newSignature(p7 *pkcs7.PKCS7, content *emime.Part) *Signature {
+	s := &Signature{Certificates: p7.Certificates, Content: content}
+	for _, signer := range p7.Signers {
+		id := signer.IssuerAndSerialNumber
+		for _, cert := range p7.Certificates {
+			if cert.SerialNumber.Cmp(id.SerialNumber) == 0 && bytes.Equal(cert.RawIssuer, id.IssuerName.FullBytes) {
+				s.Signers = append(s.Signers, cert)
+				break
+			}
+		}
+	}
+	return s
+}
+
+// Decrypt decrypts p, an `application/pkcs7-mime` part with enveloped-data,
+// with the key of the recipient cert, and parses the decrypted content.
+func Decrypt(p *emime.Part, cert *x509.Certificate, key crypto.PrivateKey) (*emime.Part, error) {
+	if !IsEncrypted(p) {
+		return nil, errors.Errorf("smime: not encrypted: %q", p.ContentType)
+	}
+	p7, err := pkcs7.Parse(p.Content)
+	if err != nil {
+		return nil, errors.Wrap(err, "smime")
+	}
+	data, err := p7.Decrypt(cert, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "smime")
+	}
+	// kept for the signature of a signed message inside
+	root, err := emime.ParseWithOptions(bytes.NewReader(data), &emime.ParseOptions{KeepRaw: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "smime: decrypted content")
+	}
+	return root, nil
+}
+
+func isPKCS7Mime(contentType string) bool {
+	return contentType == ctPKCS7Mime || contentType == ctXPKCS7Mime
+}
+
+func isSignature(contentType string) bool {
+	return contentType == ctPKCS7Signature || contentType == ctXPKCS7Signature
+}
+
+func protocol(p *emime.Part) string {
+	return strings.ToLower(param(p, "protocol"))
+}
+
+// smimeType returns the smime-type parameter, sniffed from the content
+// if it is missing.
+func smimeType(p *emime.Part) string {
+	if t := strings.ToLower(param(p, "smime-type")); t != "" {
+		return t
+	}
+	p7, err := pkcs7.Parse(p.Content)
+	if err != nil || len(p7.Signers) > 0 {
+		return smimeSignedData
+	}
+	return smimeEnvelopedData
+}
+
+func param(p *emime.Part, key string) string {
+	_, params, err := mime.ParseMediaType(p.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return params[key]
+}
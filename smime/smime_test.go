@@ -0,0 +1,218 @@
+package smime
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daogan/emime"
+	"go.mozilla.org/pkcs7"
+)
+
+func newCert(t *testing.T, name string, parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func base64Lines(data []byte) string {
+	s := base64.StdEncoding.EncodeToString(data)
+	buf := &strings.Builder{}
+	for len(s) > 76 {
+		buf.WriteString(s[:76] + "\r\n")
+		s = s[76:]
+	}
+	buf.WriteString(s + "\r\n")
+	return buf.String()
+}
+
+func TestVerifyDetached(t *testing.T) {
+	ca, caKey := newCert(t, "CA", nil, nil)
+	cert, key := newCert(t, "alice@example.com", ca, caKey)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	signed := "Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: 7bit\r\n" +
+		"\r\n" +
+		"signed text\r\n"
+	sd, err := pkcs7.NewSignedData([]byte(signed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sd.AddSigner(cert, key, pkcs7.SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	sd.Detach()
+	sig, err := sd.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := func(content string) string {
+		return "From: alice@example.com\r\n" +
+			"Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\";\r\n" +
+			"\tmicalg=sha-256; boundary=b\r\n" +
+			"\r\n" +
+			"--b\r\n" +
+			content +
+			"\r\n--b\r\n" +
+			"Content-Type: application/pkcs7-signature; name=smime.p7s\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"\r\n" +
+			base64Lines(sig) +
+			"--b--\r\n"
+	}
+
+	root, err := emime.ParseWithOptions(strings.NewReader(message(signed)), &emime.ParseOptions{KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSigned(root) {
+		t.Fatal("want signed")
+	}
+	s, err := Verify(root, roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Signers) != 1 || s.Signers[0].Subject.CommonName != "alice@example.com" {
+		t.Fatalf("got: %+v", s.Signers)
+	}
+	if string(s.Content.Content) != "signed text\r\n" {
+		t.Fatalf("got: %q", s.Content.Content)
+	}
+
+	// stored with LF line endings
+	lf := strings.Replace(message(signed), "\r\n", "\n", -1)
+	root, err = emime.ParseWithOptions(strings.NewReader(lf), &emime.ParseOptions{KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(root, roots); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err = emime.ParseWithOptions(strings.NewReader(message(strings.Replace(signed, "signed", "forged", 1))), &emime.ParseOptions{KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(root, roots); err == nil {
+		t.Fatal("want error for modified content")
+	}
+
+	other, _ := newCert(t, "Other CA", nil, nil)
+	untrusted := x509.NewCertPool()
+	untrusted.AddCert(other)
+	root, err = emime.ParseWithOptions(strings.NewReader(message(signed)), &emime.ParseOptions{KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(root, untrusted); err == nil {
+		t.Fatal("want error for untrusted signer")
+	}
+}
+
+func TestVerifyOpaque(t *testing.T) {
+	ca, caKey := newCert(t, "CA", nil, nil)
+	cert, key := newCert(t, "alice@example.com", ca, caKey)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	sd, err := pkcs7.NewSignedData([]byte("Content-Type: text/plain\r\n\r\nopaque\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sd.AddSigner(cert, key, pkcs7.SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	sig, err := sd.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := "Content-Type: application/pkcs7-mime; name=smime.p7m\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		base64Lines(sig)
+	root, err := emime.ParseWithOptions(strings.NewReader(input), &emime.ParseOptions{KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSigned(root) || IsEncrypted(root) {
+		t.Fatal("want signed, not encrypted")
+	}
+	s, err := Verify(root, roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(s.Content.Content) != "opaque\r\n" {
+		t.Fatalf("got: %q", s.Content.Content)
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	ca, caKey := newCert(t, "CA", nil, nil)
+	cert, key := newCert(t, "bob@example.com", ca, caKey)
+
+	inner := "Content-Type: multipart/mixed; boundary=inner\r\n" +
+		"\r\n" +
+		"--inner\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"secret\r\n" +
+		"--inner--\r\n"
+	enveloped, err := pkcs7.Encrypt([]byte(inner), []*x509.Certificate{cert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := "Content-Type: application/pkcs7-mime; smime-type=enveloped-data; name=smime.p7m\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		base64Lines(enveloped)
+	root, err := emime.ParseWithOptions(bytes.NewReader([]byte(input)), &emime.ParseOptions{KeepRaw: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(root) || IsSigned(root) {
+		t.Fatal("want encrypted, not signed")
+	}
+	decrypted, err := Decrypt(root, cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decrypted.Parts) != 1 || string(decrypted.Parts[0].Content) != "secret" {
+		t.Fatalf("got: %+v", decrypted)
+	}
+
+	other, otherKey := newCert(t, "eve@example.com", ca, caKey)
+	if _, err := Decrypt(root, other, otherKey); err == nil {
+		t.Fatal("want error for another recipient")
+	}
+}
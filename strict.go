@@ -0,0 +1,95 @@
+package emime
+
+import (
+	"fmt"
+	"io"
+)
+
+// rfc5322: Each line of characters MUST be no more than 998 characters,
+// excluding the CRLF.
+const maxLineLength = 998
+
+// lineChecker validates line endings and line lengths of the raw message
+// in strict mode. Data before an offending line is returned along with
+// the error, so that the error is raised when the parser reaches the line.
+type lineChecker struct {
+	r       io.Reader
+	offset  int64 // message offset of the next byte
+	lineLen int
+	cr      bool // last byte was '\r'
+	err     error
+}
+
+func newLineChecker(r io.Reader) *lineChecker {
+	return &lineChecker{r: r}
+}
+
+func (c *lineChecker) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.r.Read(p)
+	for i := 0; i < n; i++ {
+		b := p[i]
+		if c.cr {
+			c.cr = false
+			if b == '\n' {
+				c.lineLen = 0
+				continue
+			}
+			return c.fail(i-1, DefectBareCR, "CR not followed by LF")
+		}
+		switch b {
+		case '\r':
+			c.cr = true
+		case '\n':
+			return c.fail(i, DefectBareLF, "LF not preceded by CR")
+		default:
+			c.lineLen++
+			if c.lineLen > maxLineLength {
+				start := i - c.lineLen + 1
+				return c.fail(start, DefectLineTooLong,
+					"line longer than %d octets", maxLineLength)
+			}
+		}
+	}
+	if err == io.EOF && c.cr {
+		return c.fail(n-1, DefectBareCR, "CR not followed by LF")
+	}
+	c.offset += int64(n)
+	return n, err
+}
+
+// fail reports a defect at index at of the current read,
+// returning the data before it.
+func (c *lineChecker) fail(at int, kind DefectKind, format string, args ...interface{}) (int, error) {
+	c.err = &DefectError{Defect: &Defect{
+		Kind:    kind,
+		Offset:  c.offset + int64(at),
+		Message: fmt.Sprintf(format, args...),
+	}}
+	if at < 0 {
+		at = 0
+	}
+	return at, c.err
+}
+
+// invalidByte returns the index of the first byte not allowed by the
+// identity encoding cte, or -1.
+func invalidByte(buf []byte, cte string) int {
+	switch cte {
+	case cte7Bit, "":
+		for i, c := range buf {
+			if c == 0 || c > 127 {
+				return i
+			}
+		}
+	case cte8Bit:
+		for i, c := range buf {
+			if c == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
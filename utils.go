@@ -80,6 +80,12 @@ func genRandomBoundary() string {
 	return fmt.Sprintf("%028x", rand.Uint64())
 }
 
+// genMessageID generates a unique id for Message-ID and Content-ID headers,
+// without the angle brackets.
+func genMessageID(domain string) string {
+	return fmt.Sprintf("%x.%016x@%s", time.Now().UnixNano(), rand.Uint64(), domain)
+}
+
 // wrapLine wraps a long line into multiple lines of max length.
 func wrapLine(max int, line string) []byte {
 	output := make([]byte, 0)